@@ -0,0 +1,173 @@
+package v1alpha1
+
+import "k8s.io/apimachinery/pkg/runtime"
+
+func (in *SidecarPolicy) DeepCopyInto(out *SidecarPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *SidecarPolicy) DeepCopy() *SidecarPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *SidecarPolicy) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *SidecarPolicySpec) DeepCopyInto(out *SidecarPolicySpec) {
+	*out = *in
+	in.SidecarConfig.DeepCopyInto(&out.SidecarConfig)
+}
+
+func (in *SidecarPolicyStatus) DeepCopyInto(out *SidecarPolicyStatus) {
+	*out = *in
+	if in.Errors != nil {
+		out.Errors = make([]string, len(in.Errors))
+		copy(out.Errors, in.Errors)
+	}
+}
+
+func (in *SidecarPolicyList) DeepCopyInto(out *SidecarPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]SidecarPolicy, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *SidecarPolicyList) DeepCopy() *SidecarPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *SidecarPolicyList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *TeamSidecarPolicy) DeepCopyInto(out *TeamSidecarPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *TeamSidecarPolicy) DeepCopy() *TeamSidecarPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(TeamSidecarPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *TeamSidecarPolicy) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *TeamSidecarPolicyStatus) DeepCopyInto(out *TeamSidecarPolicyStatus) {
+	*out = *in
+	if in.Errors != nil {
+		out.Errors = make([]string, len(in.Errors))
+		copy(out.Errors, in.Errors)
+	}
+	if in.Adjustments != nil {
+		out.Adjustments = make([]string, len(in.Adjustments))
+		copy(out.Adjustments, in.Adjustments)
+	}
+}
+
+func (in *TeamSidecarPolicyList) DeepCopyInto(out *TeamSidecarPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]TeamSidecarPolicy, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *TeamSidecarPolicyList) DeepCopy() *TeamSidecarPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(TeamSidecarPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *TeamSidecarPolicyList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *SidecarGuardrail) DeepCopyInto(out *SidecarGuardrail) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+func (in *SidecarGuardrail) DeepCopy() *SidecarGuardrail {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarGuardrail)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *SidecarGuardrail) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *SidecarGuardrailSpec) DeepCopyInto(out *SidecarGuardrailSpec) {
+	*out = *in
+	if in.AllowedErrCodes != nil {
+		out.AllowedErrCodes = make([]int, len(in.AllowedErrCodes))
+		copy(out.AllowedErrCodes, in.AllowedErrCodes)
+	}
+}
+
+func (in *SidecarGuardrailList) DeepCopyInto(out *SidecarGuardrailList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]SidecarGuardrail, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *SidecarGuardrailList) DeepCopy() *SidecarGuardrailList {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarGuardrailList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *SidecarGuardrailList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
@@ -0,0 +1,113 @@
+// Package v1alpha1 has the custom resources das reads its config from, next to the config file.
+package v1alpha1
+
+import (
+	"github.com/bento01dev/das/internal/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SidecarPolicy is a cluster scoped sidecar config, so teams can ship das config with their charts.
+// the spec has the same fields as a sidecar in the config file, with the name of the policy as its key.
+type SidecarPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SidecarPolicySpec   `json:"spec"`
+	Status SidecarPolicyStatus `json:"status,omitempty"`
+}
+
+type SidecarPolicySpec struct {
+	config.SidecarConfig `json:",inline"`
+}
+
+type SidecarPolicyStatus struct {
+	ObservedGeneration int64 `json:"observed_generation,omitempty"`
+	// Active is true when das is using the policy.
+	Active bool `json:"active"`
+	// MatchedWorkloads is the number of deployments and daemon sets with running pods that have the container.
+	MatchedWorkloads int `json:"matched_workloads"`
+	// Errors are why das is not using the policy.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type SidecarPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SidecarPolicy `json:"items"`
+}
+
+// ContainerName is the sidecar container the policy is for.
+func (p *SidecarPolicy) ContainerName() string {
+	if p.Spec.Container != "" {
+		return p.Spec.Container
+	}
+	return p.Name
+}
+
+// TeamSidecarPolicy is a namespaced sidecar config that application teams can edit. it only applies to pods in its
+// namespace, and has to stay within the SidecarGuardrails for its container.
+type TeamSidecarPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SidecarPolicySpec       `json:"spec"`
+	Status TeamSidecarPolicyStatus `json:"status,omitempty"`
+}
+
+type TeamSidecarPolicyStatus struct {
+	ObservedGeneration int64 `json:"observed_generation,omitempty"`
+	// Active is true when das is using the policy.
+	Active bool `json:"active"`
+	// Errors are why das is not using the policy.
+	Errors []string `json:"errors,omitempty"`
+	// Adjustments are what das changed to bring the policy within the guardrails.
+	Adjustments []string `json:"adjustments,omitempty"`
+}
+
+type TeamSidecarPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TeamSidecarPolicy `json:"items"`
+}
+
+// ContainerName is the sidecar container the policy is for.
+func (p *TeamSidecarPolicy) ContainerName() string {
+	if p.Spec.Container != "" {
+		return p.Spec.Container
+	}
+	return p.Name
+}
+
+// SidecarGuardrail is the cluster scoped hard limits admins set for a sidecar container, bounding team policies.
+type SidecarGuardrail struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SidecarGuardrailSpec `json:"spec"`
+}
+
+type SidecarGuardrailSpec struct {
+	// Container is the name of the sidecar container. defaults to the name of the guardrail.
+	Container               string `json:"container,omitempty"`
+	config.ClusterGuardrail `json:",inline"`
+}
+
+type SidecarGuardrailList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SidecarGuardrail `json:"items"`
+}
+
+// ContainerName is the sidecar container the guardrail is for.
+func (g *SidecarGuardrail) ContainerName() string {
+	if g.Spec.Container != "" {
+		return g.Spec.Container
+	}
+	return g.Name
+}
+
+func init() {
+	SchemeBuilder.Register(&SidecarPolicy{}, &SidecarPolicyList{})
+	SchemeBuilder.Register(&TeamSidecarPolicy{}, &TeamSidecarPolicyList{})
+	SchemeBuilder.Register(&SidecarGuardrail{}, &SidecarGuardrailList{})
+}
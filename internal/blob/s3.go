@@ -18,6 +18,13 @@ import (
 
 var ErrStoreContextTimeout = errors.New("context timed out in storing step")
 
+// StepRecord is what gets stored for a container when das changes its step.
+type StepRecord struct {
+	config.ResourceStep
+	// MonthlyCostDelta is the estimated change in monthly cost across all of the owner's pods. it is zero without pricing in config.
+	MonthlyCostDelta float64 `json:"monthly_cost_delta,omitempty"`
+}
+
 type S3StepStore struct {
 	client     *s3.Client
 	bucketName string
@@ -41,7 +48,7 @@ func NewS3StepStore(bucketName, awsEndpoint string) (S3StepStore, error) {
 	return S3StepStore{client: client, bucketName: bucketName}, nil
 }
 
-func (store S3StepStore) UploadNewSteps(appName string, steps map[string]config.ResourceStep) (string, error) {
+func (store S3StepStore) UploadNewSteps(appName string, steps map[string]StepRecord) (string, error) {
 	data, err := json.Marshal(steps)
 	if err != nil {
 		return "", err
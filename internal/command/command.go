@@ -14,18 +14,32 @@ import (
 )
 
 func Run() error {
+	var (
+		configFilePath  string
+		printSchema     bool
+		sidecarPolicies bool
+	)
+	flag.StringVar(&configFilePath, "config_file", "config.json", "config file path, json or yaml")
+	flag.BoolVar(&printSchema, "print_schema", false, "print the json schema of the config and exit")
+	flag.BoolVar(&sidecarPolicies, "sidecar_policies", false, "add sidecars from SidecarPolicy and TeamSidecarPolicy custom resources to the config")
+	flag.Parse()
+	if printSchema {
+		schema, err := config.Schema()
+		if err != nil {
+			return fmt.Errorf("error generating config schema: %w", err)
+		}
+		fmt.Println(string(schema))
+		return nil
+	}
 	initLog()
 	slog.Info("das says hi..")
-	var configFilePath string
-	flag.StringVar(&configFilePath, "config_file", "config.json", "config file path")
-	flag.Parse()
 	slog.Info("reading config", "config_path", configFilePath)
 	conf, err := config.Parse(configFilePath)
 	if err != nil {
 		slog.Error("error parsing config", "config_path", configFilePath, "err", err.Error())
 		return err
 	}
-	err = controller.Start(conf)
+	err = controller.Start(conf, controller.Options{ConfigFilePath: configFilePath, SidecarPolicies: sidecarPolicies})
 	if err != nil {
 		slog.Error("error in starting manager", "config_path", configFilePath, "err", err.Error())
 		return fmt.Errorf("error in manager after reading config from %s: %w", configFilePath, err)
@@ -1,16 +1,25 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
 )
 
+// Owner is the kind of owner das writes the annotations to. it can be left empty for a profile to fill in.
 type Owner string
 
 func (o Owner) MarshalText() ([]byte, error) {
 	switch o {
-	case Deployment, ReplicaSet, DaemonSet:
+	case "", Deployment, ReplicaSet, DaemonSet:
 		return []byte(o), nil
 	default:
 		return nil, fmt.Errorf("unknown type: %v", o)
@@ -20,6 +29,9 @@ func (o Owner) MarshalText() ([]byte, error) {
 func (o *Owner) UnmarshalText(data []byte) error {
 	s := string(data)
 	switch s {
+	case "":
+		*o = ""
+		return nil
 	case string(Deployment):
 		*o = Deployment
 		return nil
@@ -40,6 +52,117 @@ const (
 	DaemonSet  Owner = "DaemonSet"
 )
 
+// AnnotationLevel is where das writes the resource annotations for a sidecar.
+// for a deployment, the replica set level is not an option since the deployment controller owns the replica set template.
+type AnnotationLevel string
+
+func (l *AnnotationLevel) UnmarshalText(data []byte) error {
+	s := string(data)
+	switch s {
+	case "", string(AutoLevel):
+		*l = AutoLevel
+		return nil
+	case string(OwnerLevel):
+		*l = OwnerLevel
+		return nil
+	case string(PodLevel):
+		*l = PodLevel
+		return nil
+	default:
+		return fmt.Errorf("unknown annotation level: %s", s)
+	}
+}
+
+const (
+	// AutoLevel writes each key at the level that currently defines it, falling back to the pod template.
+	AutoLevel AnnotationLevel = "auto"
+	// OwnerLevel writes to the annotations of the owner itself.
+	OwnerLevel AnnotationLevel = "owner"
+	// PodLevel writes to the annotations of the owner's pod template.
+	PodLevel AnnotationLevel = "pod"
+)
+
+// HPAPolicy is what das does about a HorizontalPodAutoscaler targeting an owner when it changes the sidecar's cpu request.
+type HPAPolicy string
+
+func (h *HPAPolicy) UnmarshalText(data []byte) error {
+	s := string(data)
+	switch s {
+	case "", string(HPAWarn):
+		*h = HPAWarn
+		return nil
+	case string(HPAIgnore):
+		*h = HPAIgnore
+		return nil
+	case string(HPAAdjust):
+		*h = HPAAdjust
+		return nil
+	default:
+		return fmt.Errorf("unknown hpa policy: %s", s)
+	}
+}
+
+const (
+	// HPAWarn logs the interaction and records it in the decision log.
+	HPAWarn HPAPolicy = "warn"
+	// HPAIgnore skips looking up HorizontalPodAutoscalers.
+	HPAIgnore HPAPolicy = "ignore"
+	// HPAAdjust rescales container resource utilization targets for the sidecar so that the absolute cpu at which the HPA scales stays the same.
+	HPAAdjust HPAPolicy = "adjust"
+)
+
+// ConstraintPolicy is what das does when a step change would break a constraint of the cluster, like a resource quota.
+type ConstraintPolicy string
+
+func (c *ConstraintPolicy) UnmarshalText(data []byte) error {
+	s := string(data)
+	switch s {
+	case "", string(ConstraintHold):
+		*c = ConstraintHold
+		return nil
+	case string(ConstraintCap):
+		*c = ConstraintCap
+		return nil
+	case string(ConstraintIgnore):
+		*c = ConstraintIgnore
+		return nil
+	default:
+		return fmt.Errorf("unknown constraint policy: %s", s)
+	}
+}
+
+const (
+	// ConstraintHold keeps the container on its current step and tries again on the next restart.
+	ConstraintHold ConstraintPolicy = "hold"
+	// ConstraintCap moves to the highest step between the current and the next step that fits, holding if none does.
+	ConstraintCap ConstraintPolicy = "cap"
+	// ConstraintIgnore applies the step change anyway and only notes it in the decision log.
+	ConstraintIgnore ConstraintPolicy = "ignore"
+)
+
+// QoSPolicy is the QoS class das keeps a sidecar's pods in when applying steps.
+type QoSPolicy string
+
+func (q *QoSPolicy) UnmarshalText(data []byte) error {
+	s := string(data)
+	switch s {
+	case "", string(QoSGuaranteed), string(QoSBurstable), string(QoSPreserve):
+		*q = QoSPolicy(s)
+		return nil
+	default:
+		return fmt.Errorf("unknown qos policy: %s", s)
+	}
+}
+
+const (
+	// QoSGuaranteed needs every step to have requests equal to limits for cpu and memory, and the pod to stay Guaranteed.
+	QoSGuaranteed QoSPolicy = "Guaranteed"
+	// QoSBurstable needs every step to set some requests or limits, and the pod to stay Burstable.
+	QoSBurstable QoSPolicy = "Burstable"
+	// QoSPreserve keeps whatever QoS class the pod has.
+	QoSPreserve QoSPolicy = "preserve"
+)
+
 type ResourceStep struct {
 	Name         string `json:"name"`
 	RestartLimit int    `json:"restart_limit"`
@@ -49,31 +172,204 @@ type ResourceStep struct {
 	MemLimit     string `json:"mem_limit"`
 }
 
+// VPAConfig links a sidecar to a VerticalPodAutoscaler for the owner.
+type VPAConfig struct {
+	// Enabled publishes the current das step as the minAllowed for the sidecar in the owner's VPA,
+	// creating a VPA in recommendation mode if the owner has none.
+	Enabled bool `json:"enabled"`
+	// UseRecommendation lets das skip ahead to the first step covering the VPA's target recommendation when stepping up.
+	UseRecommendation bool `json:"use_recommendation"`
+}
+
+// FloorRule keeps a sidecar on at least Step during a weekly window, like "Mon-Fri 08:00 to 11:00".
+// das goes back to the learnt step once the window is over.
+type FloorRule struct {
+	Step string `json:"step"`
+	// Days is a range like "Mon-Fri" or a list like "Sat,Sun". empty means every day.
+	Days string `json:"days"`
+	// Start and End are times of day like "08:00". an End before Start runs overnight.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Timezone is an IANA timezone name. defaults to UTC.
+	Timezone string `json:"timezone"`
+	// Lead is how long before Start the floor is applied, so pods have rolled before the spike, like "20m".
+	Lead string `json:"lead"`
+}
+
 type SidecarConfig struct {
-	ErrCodes              []int          `json:"err_codes"`
-	Owner                 Owner          `json:"owner"`
-	Steps                 []ResourceStep `json:"steps"`
-	CPUAnnotationKey      string         `json:"cpu_annotation_key"`
-	CPULimitAnnotationKey string         `json:"cpu_limit_annotation_key"`
-	MemAnnotationKey      string         `json:"mem_annotation_key"`
-	MemLimitAnnotationKey string         `json:"mem_limit_annotation_key"`
+	// Container is the name of the sidecar container. defaults to the sidecar's key in the config,
+	// so several sidecar configs can be set for the same container with different selectors.
+	// it can be a glob like log-shipper-* to cover a family of sidecars, with annotation keys written as templates
+	// like {{.Container}}/cpu, filled in with the name of the matched container.
+	Container string `json:"container"`
+	// ContainerRegex matches container names with a regex instead, which has to match the whole name.
+	ContainerRegex string `json:"container_regex"`
+	// Profile fills in the container name, err codes, owner, steps and annotation keys the sidecar config leaves empty
+	// from a built-in profile for a well known injector.
+	Profile Profile `json:"profile"`
+	// NamespaceSelector and PodSelector limit the sidecar config to pods in matching namespaces and with matching labels.
+	// empty selectors match everything.
+	NamespaceSelector *metav1.LabelSelector `json:"namespace_selector"`
+	PodSelector       *metav1.LabelSelector `json:"pod_selector"`
+	// Priority picks between sidecar configs for the same container matching a pod, highest first.
+	// ties go to the first key in name order.
+	Priority              int              `json:"priority"`
+	ErrCodes              []int            `json:"err_codes"`
+	Owner                 Owner            `json:"owner,omitempty"`
+	Steps                 []ResourceStep   `json:"steps"`
+	CPUAnnotationKey      string           `json:"cpu_annotation_key"`
+	CPULimitAnnotationKey string           `json:"cpu_limit_annotation_key"`
+	MemAnnotationKey      string           `json:"mem_annotation_key"`
+	MemLimitAnnotationKey string           `json:"mem_limit_annotation_key"`
+	AnnotationLevel       AnnotationLevel  `json:"annotation_level"`
+	HPAPolicy             HPAPolicy        `json:"hpa_policy"`
+	VPA                   VPAConfig        `json:"vpa"`
+	QuotaPolicy           ConstraintPolicy `json:"quota_policy"`
+	NodeCapacityPolicy    ConstraintPolicy `json:"node_capacity_policy"`
+	Floors                []FloorRule      `json:"floors"`
+	QoS                   QoSPolicy        `json:"qos"`
+	NodePoolLadders       []NodePoolLadder `json:"node_pool_ladders"`
+	// ImageResetStep is the step das drops back to, with counts reset, when the sidecar's image changes.
+	// empty keeps the learnt step across image changes.
+	ImageResetStep string `json:"image_reset_step"`
+	// Guardrails bound what owners can change with the das/overrides annotation.
+	Guardrails Guardrails `json:"guardrails"`
+	// Ladder uses one of the config's ladders as the steps, tailored with LadderAdjustment.
+	Ladder           string           `json:"ladder"`
+	LadderAdjustment LadderAdjustment `json:"ladder_adjustment"`
+}
+
+// NodePoolLadder is a ladder used instead of Steps for pods on nodes matching NodeSelector.
+// Name is the node class das tracks the sidecar's step under.
+type NodePoolLadder struct {
+	Name         string            `json:"name"`
+	NodeSelector map[string]string `json:"node_selector"`
+	Steps        []ResourceStep    `json:"steps"`
+}
+
+// BudgetConfig caps the extra cpu and memory requests das hands out across the cluster,
+// counted as the requests of each container's current step minus its first step, times the owner's pods.
+type BudgetConfig struct {
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+	// Policy is what das does with a step change that would go over the budget. cap and hold work as they do for quotas.
+	Policy ConstraintPolicy `json:"policy"`
+	// ExemptPriorityClasses and ExemptNamespaces let step changes for pods of those priority classes or namespaces go over the budget.
+	ExemptPriorityClasses []string `json:"exempt_priority_classes"`
+	ExemptNamespaces      []string `json:"exempt_namespaces"`
+}
+
+// PricingConfig is used to estimate the monthly cost of each step change.
+type PricingConfig struct {
+	CPUHour float64 `json:"cpu_hour"`
+	GiBHour float64 `json:"gib_hour"`
 }
 
 type Config struct {
 	LabelName string                   `json:"app_label_name"`
 	Sidecars  map[string]SidecarConfig `json:"sidecars"`
+	Budget    *BudgetConfig            `json:"budget"`
+	Pricing   *PricingConfig           `json:"pricing"`
+	// Ladders are named ladders sidecars can share, and owners can pick with the das/ladder annotation.
+	Ladders map[string][]ResourceStep `json:"ladders"`
+}
+
+// Workload is what the selectors of a sidecar config are matched against.
+type Workload struct {
+	NamespaceLabels map[string]string
+	PodLabels       map[string]string
+}
+
+// ContainerName is the container the sidecar config under key is for.
+func (c Config) ContainerName(key string) string {
+	if container := c.Sidecars[key].Container; container != "" {
+		return container
+	}
+	return key
+}
+
+// SidecarFor returns the key and sidecar config that applies to the container in the workload, if any, with templated
+// annotation keys filled in. when priorities tie, a sidecar config naming the container wins over a pattern, then
+// the first key in name order.
+func (c Config) SidecarFor(containerName string, workload Workload) (string, SidecarConfig, bool) {
+	var (
+		res        SidecarConfig
+		resKey     string
+		resPattern bool
+		found      bool
+	)
+	for key, sidecarConfig := range c.Sidecars {
+		if !c.MatchesContainer(key, containerName) || !sidecarConfig.Matches(workload) {
+			continue
+		}
+		pattern := c.IsPattern(key)
+		if found && (sidecarConfig.Priority < res.Priority ||
+			sidecarConfig.Priority == res.Priority && (pattern && !resPattern || pattern == resPattern && key > resKey)) {
+			continue
+		}
+		res, resKey, resPattern, found = sidecarConfig, key, pattern, true
+	}
+	if !found {
+		return resKey, res, false
+	}
+	res, err := res.ForContainer(containerName)
+	if err != nil {
+		return resKey, res, false
+	}
+	return resKey, res, true
+}
+
+// Matches reports whether the sidecar config's selectors match the workload. a selector that does not parse matches nothing.
+func (s SidecarConfig) Matches(workload Workload) bool {
+	return selectorMatches(s.NamespaceSelector, workload.NamespaceLabels) && selectorMatches(s.PodSelector, workload.PodLabels)
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	if selector == nil {
+		return true
+	}
+	parsed, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return parsed.Matches(labels.Set(set))
 }
 
-// TODO: add cue validation if needed
+// Parse reads the config file as YAML if it has a .yaml or .yml extension or does not look like JSON, and as JSON otherwise.
 func Parse(configFilePath string) (Config, error) {
 	var config Config
-	f, err := os.Open(configFilePath)
+	data, err := os.ReadFile(configFilePath)
 	if err != nil {
 		return config, fmt.Errorf("error opening config file in path %s: %w", configFilePath, err)
 	}
-	err = json.NewDecoder(f).Decode(&config)
+	if isYAML(configFilePath, data) {
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return config, fmt.Errorf("yaml parsing error for config in path %s: %w", configFilePath, err)
+		}
+	}
+	err = json.Unmarshal(data, &config)
 	if err != nil {
 		return config, fmt.Errorf("json parsing error for config in path %s: %w", configFilePath, err)
 	}
+	ladderErr := config.withLadders()
+	err = config.withProfiles()
+	if err != nil {
+		return config, fmt.Errorf("invalid config in path %s: %w", configFilePath, errors.Join(ladderErr, err))
+	}
+	err = errors.Join(ladderErr, config.Validate())
+	if err != nil {
+		return config, fmt.Errorf("invalid config in path %s: %w", configFilePath, err)
+	}
 	return config, nil
 }
+
+func isYAML(configFilePath string, data []byte) bool {
+	switch strings.ToLower(filepath.Ext(configFilePath)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+	return !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
@@ -0,0 +1,736 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validSidecar() SidecarConfig {
+	return SidecarConfig{
+		Owner:                 Deployment,
+		CPUAnnotationKey:      "test-sidecar/cpu",
+		CPULimitAnnotationKey: "test-sidecar/cpuLimit",
+		MemAnnotationKey:      "test-sidecar/mem",
+		MemLimitAnnotationKey: "test-sidecar/memLimit",
+		Steps: []ResourceStep{
+			{Name: "test-step-1", RestartLimit: 5, CPURequest: "1", CPULimit: "1", MemRequest: "1Gi", MemLimit: "1Gi"},
+			{Name: "test-step-2", RestartLimit: 5, CPURequest: "2", CPULimit: "2", MemRequest: "3Gi", MemLimit: "3Gi"},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testcases := []struct {
+		name   string
+		modify func(sidecarConfig *SidecarConfig)
+		errs   []string
+	}{
+		{
+			name:   "valid sidecar",
+			modify: func(sidecarConfig *SidecarConfig) {},
+		},
+		{
+			name:   "empty steps",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.Steps = nil },
+			errs:   []string{"sidecar test-sidecar: steps must not be empty"},
+		},
+		{
+			name:   "unparseable quantity",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.Steps[1].CPURequest = "2cores" },
+			errs:   []string{`sidecar test-sidecar: step test-step-2: cpu_request "2cores" is not a valid quantity`},
+		},
+		{
+			name:   "request more than limit",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.Steps[1].MemRequest = "4Gi" },
+			errs:   []string{"sidecar test-sidecar: step test-step-2: mem_request 4Gi is more than mem_limit 3Gi"},
+		},
+		{
+			name: "ladder going down",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.Steps[1].CPURequest = "500m"
+				sidecarConfig.Steps[1].CPULimit = "500m"
+			},
+			errs: []string{
+				"sidecar test-sidecar: step test-step-2: cpu_limit 500m is less than in step test-step-1",
+				"sidecar test-sidecar: step test-step-2: cpu_request 500m is less than in step test-step-1",
+			},
+		},
+		{
+			name: "ladder not growing",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.Steps[1] = sidecarConfig.Steps[0]
+				sidecarConfig.Steps[1].Name = "test-step-2"
+			},
+			errs: []string{"sidecar test-sidecar: step test-step-2: must be bigger than step test-step-1 in at least one value"},
+		},
+		{
+			name:   "duplicate step names",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.Steps[1].Name = "test-step-1" },
+			errs:   []string{"sidecar test-sidecar: step test-step-1: duplicate name"},
+		},
+		{
+			name:   "missing annotation key",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.MemLimitAnnotationKey = "" },
+			errs: []string{
+				"sidecar test-sidecar: step test-step-1: mem_limit is set but mem_limit_annotation_key is not",
+				"sidecar test-sidecar: step test-step-2: mem_limit is set but mem_limit_annotation_key is not",
+			},
+		},
+		{
+			name:   "missing owner",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.Owner = "" },
+			errs:   []string{`sidecar test-sidecar: owner must be Deployment or DaemonSet, got ""`},
+		},
+		{
+			name: "guaranteed qos with requests below limits",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.QoS = QoSGuaranteed
+				sidecarConfig.Steps[1].CPURequest = "1500m"
+			},
+			errs: []string{"sidecar test-sidecar: step test-step-2: qos Guaranteed needs cpu and memory requests equal to limits"},
+		},
+		{
+			name: "burstable qos without any requests or limits",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.QoS = QoSBurstable
+				sidecarConfig.Steps = append(sidecarConfig.Steps, ResourceStep{Name: "test-step-3"})
+			},
+			errs: []string{"sidecar test-sidecar: step test-step-3: qos Burstable needs at least one request or limit"},
+		},
+		{
+			name: "floor on an unknown step",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.Floors = []FloorRule{{Step: "test-step-3", Start: "08:00", End: "11:00"}}
+			},
+			errs: []string{"sidecar test-sidecar: floor 0: step test-step-3 is not one of the steps"},
+		},
+		{
+			name: "floor with invalid days",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.Floors = []FloorRule{{Step: "test-step-2", Days: "Mon–Fri", Start: "08:00", End: "11:00"}}
+			},
+			errs: []string{"sidecar test-sidecar: floor 0: invalid days Mon–Fri, expected a range like Mon-Fri or a list like Sat,Sun"},
+		},
+		{
+			name: "floor with an empty window",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.Floors = []FloorRule{{Step: "test-step-2", Start: "08:00", End: "08:00"}}
+			},
+			errs: []string{"sidecar test-sidecar: floor 0: start and end are both 08:00, so the window is empty"},
+		},
+		{
+			name:   "invalid container pattern",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.Container = "log-shipper-[" },
+			errs:   []string{"sidecar test-sidecar: invalid container pattern: syntax error in pattern"},
+		},
+		{
+			name: "container and container regex",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.Container = "log-shipper"
+				sidecarConfig.ContainerRegex = "log-shipper-(a|b)"
+			},
+			errs: []string{"sidecar test-sidecar: container and container_regex must not both be set"},
+		},
+		{
+			name:   "invalid annotation key template",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.CPUAnnotationKey = "{{.Containr}}/cpu" },
+			errs:   []string{"sidecar test-sidecar: error rendering annotation key {{.Containr}}/cpu"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sidecarConfig := validSidecar()
+			testcase.modify(&sidecarConfig)
+			conf := Config{Sidecars: map[string]SidecarConfig{"test-sidecar": sidecarConfig}}
+			err := conf.Validate()
+			if len(testcase.errs) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			for _, msg := range testcase.errs {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	jsonConfig := `{"sidecars": {"test-sidecar": {"owner": "Deployment", "cpu_annotation_key": "test-sidecar/cpu", "steps": [{"name": "test-step-1", "cpu_request": "1"}]}}}`
+	yamlConfig := `
+sidecars:
+  test-sidecar:
+    owner: Deployment
+    cpu_annotation_key: test-sidecar/cpu
+    steps:
+      - name: test-step-1
+        cpu_request: "1"
+`
+	testcases := []struct {
+		name     string
+		fileName string
+		content  string
+	}{
+		{name: "json by extension", fileName: "config.json", content: jsonConfig},
+		{name: "yaml by extension", fileName: "config.yaml", content: yamlConfig},
+		{name: "json by content", fileName: "config", content: jsonConfig},
+		{name: "yaml by content", fileName: "config", content: yamlConfig},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), testcase.fileName)
+			assert.NoError(t, os.WriteFile(path, []byte(testcase.content), 0o600))
+			conf, err := Parse(path)
+			assert.NoError(t, err)
+			assert.Equal(t, Deployment, conf.Sidecars["test-sidecar"].Owner)
+			assert.Equal(t, "1", conf.Sidecars["test-sidecar"].Steps[0].CPURequest)
+		})
+	}
+}
+
+func TestParseLadders(t *testing.T) {
+	content := `
+ladders:
+  small:
+    - name: small-1
+      cpu_request: 100m
+sidecars:
+  a-sidecar:
+    owner: Deployment
+    cpu_annotation_key: a-sidecar/cpu
+    ladder: medium
+  b-sidecar:
+    owner: Deployment
+    cpu_annotation_key: b-sidecar/cpu
+    ladder: small
+    steps:
+      - name: test-step-1
+        cpu_request: "1"
+  c-sidecar:
+    owner: Deployment
+    cpu_annotation_key: c-sidecar/cpu
+    ladder: small
+    ladder_adjustment:
+      start_step: small-2
+  d-sidecar:
+    owner: Deployment
+    ladder: small
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	_, err := Parse(path)
+	assert.ErrorContains(t, err, "sidecar a-sidecar: ladder medium is not one of the ladders")
+	assert.ErrorContains(t, err, "sidecar b-sidecar: steps and ladder must not both be set")
+	assert.ErrorContains(t, err, "sidecar c-sidecar: ladder small:")
+	assert.ErrorContains(t, err, "sidecar d-sidecar: at least one annotation key must be set")
+}
+
+func TestSchemaUpToDate(t *testing.T) {
+	schema, err := Schema()
+	assert.NoError(t, err)
+	published, err := os.ReadFile("../../config.schema.json")
+	assert.NoError(t, err)
+	assert.Equal(t, string(schema)+"\n", string(published), "config.schema.json is out of date. regenerate it with go run ./cmd/das -print_schema > config.schema.json")
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(cpuRequest string) {
+		content := "sidecars:\n  test-sidecar:\n    owner: Deployment\n    cpu_annotation_key: test-sidecar/cpu\n    steps:\n      - name: test-step-1\n        cpu_request: \"" + cpuRequest + "\"\n"
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	write("1")
+	conf, err := Parse(path)
+	assert.NoError(t, err)
+	store := NewStore(conf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan error, 10)
+	go Watch(ctx, path, store, func(err error) { results <- err })
+	next := func() error {
+		select {
+		case err := <-results:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("config was not reloaded")
+			return nil
+		}
+	}
+	// give the watcher a moment to start before changing the file
+	time.Sleep(100 * time.Millisecond)
+
+	write("2cores")
+	assert.Error(t, next())
+	assert.Equal(t, "1", store.Load().Sidecars["test-sidecar"].Steps[0].CPURequest, "invalid config must not be put in use")
+
+	write("2")
+	assert.NoError(t, next())
+	assert.Equal(t, "2", store.Load().Sidecars["test-sidecar"].Steps[0].CPURequest)
+}
+
+func TestStoreOverlay(t *testing.T) {
+	fileSidecar := SidecarConfig{Owner: Deployment}
+	policySidecar := SidecarConfig{Owner: DaemonSet}
+	store := NewStore(Config{LabelName: "test-label", Sidecars: map[string]SidecarConfig{"test-sidecar": fileSidecar}})
+	store.SetOverlay(map[string]SidecarConfig{"test-sidecar": policySidecar, "other-sidecar": policySidecar})
+	assert.Equal(t, Config{LabelName: "test-label", Sidecars: map[string]SidecarConfig{"test-sidecar": fileSidecar, "other-sidecar": policySidecar}}, store.Load())
+
+	store.Swap(Config{LabelName: "test-label"})
+	assert.Equal(t, Config{LabelName: "test-label", Sidecars: map[string]SidecarConfig{"test-sidecar": policySidecar, "other-sidecar": policySidecar}}, store.Load())
+	assert.Equal(t, Config{LabelName: "test-label"}, store.Base())
+}
+
+func TestStoreReloads(t *testing.T) {
+	store := NewStore(Config{})
+	reloads := store.Reloads()
+	store.SetOverlay(map[string]SidecarConfig{"test-sidecar": {Owner: Deployment}})
+	assert.Empty(t, reloads)
+
+	store.Swap(Config{LabelName: "test-label"})
+	store.Swap(Config{LabelName: "other-label"})
+	assert.Len(t, reloads, 1)
+	<-reloads
+	assert.Empty(t, reloads)
+}
+
+func TestWithOverride(t *testing.T) {
+	sidecarConfig := validSidecar()
+	sidecarConfig.Steps = append(sidecarConfig.Steps, ResourceStep{Name: "test-step-3", RestartLimit: 5, CPURequest: "4", CPULimit: "4", MemRequest: "6Gi", MemLimit: "6Gi"})
+	sidecarConfig.Guardrails = Guardrails{AllowOverrides: true, AllowCustomSteps: true, MaxCPU: "3", MinRestartLimit: 2}
+	testcases := []struct {
+		name       string
+		guardrails *Guardrails
+		override   Override
+		steps      []string
+		restarts   int
+		err        string
+	}{
+		{
+			name:     "start and max step",
+			override: Override{StartStep: "test-step-2", MaxStep: "test-step-2"},
+			steps:    []string{"test-step-2"},
+			restarts: 5,
+		},
+		{
+			name:     "restart limit",
+			override: Override{MaxStep: "test-step-2", RestartLimit: 3},
+			steps:    []string{"test-step-1", "test-step-2"},
+			restarts: 3,
+		},
+		{
+			name:     "steps above the guardrail",
+			override: Override{StartStep: "test-step-2"},
+			err:      "step test-step-3: cpu_request 4 is above the guardrail of 3",
+		},
+		{
+			name:     "restart limit below the guardrail",
+			override: Override{MaxStep: "test-step-1", RestartLimit: 1},
+			err:      "step test-step-1: restart_limit 1 is below the guardrail of 2",
+		},
+		{
+			name:     "custom steps",
+			override: Override{Steps: []ResourceStep{{Name: "custom-1", RestartLimit: 2, CPURequest: "500m"}, {Name: "custom-2", RestartLimit: 2, CPURequest: "1"}}},
+			steps:    []string{"custom-1", "custom-2"},
+			restarts: 2,
+		},
+		{
+			name:       "custom steps not allowed",
+			guardrails: &Guardrails{AllowOverrides: true},
+			override:   Override{Steps: []ResourceStep{{Name: "custom-1", CPURequest: "500m"}}},
+			err:        "custom steps are not allowed for this sidecar",
+		},
+		{
+			name:       "overrides not allowed",
+			guardrails: &Guardrails{},
+			override:   Override{StartStep: "test-step-2"},
+			err:        "overrides are not allowed for this sidecar",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sidecarConfig := sidecarConfig
+			if testcase.guardrails != nil {
+				sidecarConfig.Guardrails = *testcase.guardrails
+			}
+			res, err := sidecarConfig.WithOverride(testcase.override)
+			if testcase.err != "" {
+				assert.ErrorContains(t, err, testcase.err)
+				assert.Equal(t, sidecarConfig, res)
+				return
+			}
+			assert.NoError(t, err)
+			var names []string
+			for _, step := range res.Steps {
+				names = append(names, step.Name)
+				assert.Equal(t, testcase.restarts, step.RestartLimit)
+			}
+			assert.Equal(t, testcase.steps, names)
+			assert.Equal(t, 5, sidecarConfig.Steps[0].RestartLimit, "the sidecar config must not change")
+		})
+	}
+}
+
+func TestEnforceGuardrail(t *testing.T) {
+	sidecarConfig := validSidecar()
+	sidecarConfig.ErrCodes = []int{1, 137}
+	testcases := []struct {
+		name        string
+		guardrail   ClusterGuardrail
+		steps       []string
+		errCodes    []int
+		adjustments int
+		err         string
+	}{
+		{
+			name:      "within the guardrail",
+			guardrail: ClusterGuardrail{MaxCPU: "2", MaxMemory: "4Gi", MinRestartLimit: 3},
+			steps:     []string{"test-step-1", "test-step-2"},
+			errCodes:  []int{1, 137},
+		},
+		{
+			name:      "rejected",
+			guardrail: ClusterGuardrail{MaxCPU: "1"},
+			err:       "step test-step-2: cpu_request 2 is above the guardrail of 1",
+		},
+		{
+			name:        "clamped",
+			guardrail:   ClusterGuardrail{MaxMemory: "2Gi", AllowedErrCodes: []int{137}, Action: GuardrailClamp},
+			steps:       []string{"test-step-1", "test-step-2"},
+			errCodes:    []int{137},
+			adjustments: 3,
+		},
+		{
+			name:        "step dropped after clamping",
+			guardrail:   ClusterGuardrail{MaxCPU: "1", MaxMemory: "1Gi", Action: GuardrailClamp},
+			steps:       []string{"test-step-1"},
+			errCodes:    []int{1, 137},
+			adjustments: 5,
+		},
+		{
+			name:      "no err codes allowed",
+			guardrail: ClusterGuardrail{AllowedErrCodes: []int{0}, Action: GuardrailClamp},
+			err:       "none of the err codes are allowed",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res, adjustments, err := testcase.guardrail.Enforce(sidecarConfig)
+			if testcase.err != "" {
+				assert.ErrorContains(t, err, testcase.err)
+				return
+			}
+			assert.NoError(t, err)
+			var names []string
+			for _, step := range res.Steps {
+				names = append(names, step.Name)
+			}
+			assert.Equal(t, testcase.steps, names)
+			assert.Equal(t, testcase.errCodes, res.ErrCodes)
+			assert.Len(t, adjustments, testcase.adjustments)
+			assert.Equal(t, "2", sidecarConfig.Steps[1].CPURequest, "the sidecar config must not change")
+		})
+	}
+}
+
+func TestEnforceGuardrailTeamFields(t *testing.T) {
+	armLadder := NodePoolLadder{Name: "arm", Steps: []ResourceStep{
+		{Name: "arm-small", RestartLimit: 5, MemRequest: "1Gi"},
+		{Name: "arm-large", RestartLimit: 5, MemRequest: "64Gi"},
+	}}
+	testcases := []struct {
+		name        string
+		modify      func(sidecarConfig *SidecarConfig)
+		action      GuardrailAction
+		check       func(t *testing.T, res SidecarConfig)
+		adjustments []string
+		err         string
+	}{
+		{
+			name:   "node pool ladder rejected",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.NodePoolLadders = []NodePoolLadder{armLadder} },
+			err:    "node pool ladder arm: step arm-large: mem_request 64Gi is above the guardrail of 4Gi",
+		},
+		{
+			name:   "node pool ladder clamped",
+			modify: func(sidecarConfig *SidecarConfig) { sidecarConfig.NodePoolLadders = []NodePoolLadder{armLadder} },
+			action: GuardrailClamp,
+			check: func(t *testing.T, res SidecarConfig) {
+				assert.Equal(t, "4Gi", res.NodePoolLadders[0].Steps[1].MemRequest)
+			},
+			adjustments: []string{"node pool ladder arm: clamped mem_request of step arm-large from 64Gi to 4Gi"},
+		},
+		{
+			name: "guardrails for overrides tightened",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.Guardrails = Guardrails{AllowOverrides: true, AllowCustomSteps: true, MaxMemory: "not-a-quantity"}
+			},
+			check: func(t *testing.T, res SidecarConfig) {
+				assert.Equal(t, Guardrails{AllowOverrides: true, AllowCustomSteps: true, MaxCPU: "2", MaxMemory: "4Gi", MinRestartLimit: 2}, res.Guardrails)
+				_, err := res.WithOverride(Override{Steps: []ResourceStep{{Name: "huge", RestartLimit: 5, MemRequest: "100Gi"}}})
+				assert.ErrorContains(t, err, "step huge: mem_request 100Gi is above the guardrail of 4Gi")
+			},
+			adjustments: []string{"tightened guardrails for overrides to the cluster guardrail"},
+		},
+		{
+			name: "stricter team guardrails kept",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.Guardrails = Guardrails{AllowOverrides: true, MaxCPU: "1", MaxMemory: "1Gi", MinRestartLimit: 3}
+			},
+			check: func(t *testing.T, res SidecarConfig) {
+				assert.Equal(t, Guardrails{AllowOverrides: true, MaxCPU: "1", MaxMemory: "1Gi", MinRestartLimit: 3}, res.Guardrails)
+			},
+		},
+		{
+			name: "loosened policies rejected",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.HPAPolicy = HPAAdjust
+				sidecarConfig.QuotaPolicy = ConstraintIgnore
+				sidecarConfig.NodeCapacityPolicy = ConstraintIgnore
+			},
+			err: "hpa_policy adjust is not allowed\nquota_policy ignore is not allowed\nnode_capacity_policy ignore is not allowed",
+		},
+		{
+			name: "loosened policies clamped",
+			modify: func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.HPAPolicy = HPAAdjust
+				sidecarConfig.QuotaPolicy = ConstraintIgnore
+				sidecarConfig.NodeCapacityPolicy = ConstraintCap
+			},
+			action: GuardrailClamp,
+			check: func(t *testing.T, res SidecarConfig) {
+				assert.Equal(t, HPAWarn, res.HPAPolicy)
+				assert.Equal(t, ConstraintHold, res.QuotaPolicy)
+				assert.Equal(t, ConstraintCap, res.NodeCapacityPolicy)
+			},
+			adjustments: []string{"set hpa_policy to warn", "set quota_policy to hold"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sidecarConfig := validSidecar()
+			testcase.modify(&sidecarConfig)
+			guardrail := ClusterGuardrail{MaxCPU: "2", MaxMemory: "4Gi", MinRestartLimit: 2, Action: testcase.action}
+			res, adjustments, err := guardrail.Enforce(sidecarConfig)
+			if testcase.err != "" {
+				assert.EqualError(t, err, testcase.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testcase.adjustments, adjustments)
+			testcase.check(t, res)
+		})
+	}
+}
+
+func TestWithProfile(t *testing.T) {
+	testcases := []struct {
+		name          string
+		sidecarConfig SidecarConfig
+		container     string
+		cpuKey        string
+		steps         int
+		err           string
+	}{
+		{
+			name:          "profile only",
+			sidecarConfig: SidecarConfig{Profile: ProfileIstio},
+			container:     "istio-proxy",
+			cpuKey:        "sidecar.istio.io/proxyCPU",
+			steps:         3,
+		},
+		{
+			name:          "config takes precedence",
+			sidecarConfig: SidecarConfig{Profile: ProfileLinkerd, Container: "proxy", CPUAnnotationKey: "test-sidecar/cpu", Steps: validSidecar().Steps},
+			container:     "proxy",
+			cpuKey:        "test-sidecar/cpu",
+			steps:         2,
+		},
+		{
+			name:          "datadog has no annotation keys",
+			sidecarConfig: SidecarConfig{Profile: ProfileDatadog},
+			steps:         3,
+		},
+		{
+			name:          "unknown profile",
+			sidecarConfig: SidecarConfig{Profile: "envoy"},
+			err:           "unknown profile envoy",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res, err := testcase.sidecarConfig.WithProfile()
+			if testcase.err != "" {
+				assert.ErrorContains(t, err, testcase.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testcase.container, res.Container)
+			assert.Equal(t, testcase.cpuKey, res.CPUAnnotationKey)
+			assert.Len(t, res.Steps, testcase.steps)
+			assert.Equal(t, []int{137}, res.ErrCodes)
+			assert.Equal(t, Deployment, res.Owner)
+		})
+	}
+}
+
+func TestProfilesValid(t *testing.T) {
+	for name := range profiles {
+		if name == ProfileDatadog {
+			continue
+		}
+		conf := Config{Sidecars: map[string]SidecarConfig{string(name): {Profile: name}}}
+		assert.NoError(t, conf.withProfiles())
+		assert.NoError(t, conf.Validate(), "profile %s", name)
+	}
+}
+
+func TestSidecarFor(t *testing.T) {
+	sidecar := func(container string, containerRegex string, cpuKey string) SidecarConfig {
+		return SidecarConfig{Container: container, ContainerRegex: containerRegex, CPUAnnotationKey: cpuKey}
+	}
+	conf := Config{Sidecars: map[string]SidecarConfig{
+		"log-shippers":  sidecar("log-shipper-*", "", "{{.Container}}/cpu"),
+		"log-shipper-c": sidecar("", "", "log-shipper-c/cpu"),
+		"proxies":       sidecar("", "(envoy|nginx)-proxy", "proxy.example.com/{{.Container}}-cpu"),
+	}}
+	testcases := []struct {
+		name      string
+		container string
+		key       string
+		cpuKey    string
+	}{
+		{name: "glob", container: "log-shipper-a", key: "log-shippers", cpuKey: "log-shipper-a/cpu"},
+		{name: "name wins over pattern", container: "log-shipper-c", key: "log-shipper-c", cpuKey: "log-shipper-c/cpu"},
+		{name: "regex", container: "nginx-proxy", key: "proxies", cpuKey: "proxy.example.com/nginx-proxy-cpu"},
+		{name: "regex matches the whole name", container: "nginx-proxy-2"},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			key, sidecarConfig, ok := conf.SidecarFor(testcase.container, Workload{})
+			assert.Equal(t, testcase.key != "", ok)
+			assert.Equal(t, testcase.key, key)
+			if ok {
+				assert.Equal(t, testcase.cpuKey, sidecarConfig.CPUAnnotationKey)
+			}
+		})
+	}
+}
+
+func TestLadders(t *testing.T) {
+	conf := Config{Ladders: map[string][]ResourceStep{
+		"small": {
+			{Name: "small-1", RestartLimit: 5, CPURequest: "100m", MemRequest: "128Mi"},
+			{Name: "small-2", RestartLimit: 5, CPURequest: "250m", MemRequest: "256Mi"},
+			{Name: "small-3", RestartLimit: 5, CPURequest: "500m", MemRequest: "512Mi"},
+		},
+		"large": {
+			{Name: "large-1", RestartLimit: 5, CPURequest: "1", MemRequest: "1Gi"},
+			{Name: "large-2", RestartLimit: 5, CPURequest: "4", MemRequest: "4Gi"},
+		},
+	}}
+	sidecar := func(modify func(sidecarConfig *SidecarConfig)) SidecarConfig {
+		sidecarConfig := validSidecar()
+		sidecarConfig.Steps = nil
+		sidecarConfig.Ladder = "small"
+		sidecarConfig.Guardrails = Guardrails{AllowOverrides: true, MaxCPU: "2"}
+		modify(&sidecarConfig)
+		return sidecarConfig
+	}
+	testcases := []struct {
+		name          string
+		sidecarConfig SidecarConfig
+		workload      string
+		steps         []string
+		restarts      int
+		err           string
+	}{
+		{
+			name:          "ladder",
+			sidecarConfig: sidecar(func(sidecarConfig *SidecarConfig) {}),
+			steps:         []string{"small-1", "small-2", "small-3"},
+			restarts:      5,
+		},
+		{
+			name: "adjusted ladder",
+			sidecarConfig: sidecar(func(sidecarConfig *SidecarConfig) {
+				sidecarConfig.LadderAdjustment = LadderAdjustment{StartStep: "small-2", RestartLimit: 3}
+			}),
+			steps:    []string{"small-2", "small-3"},
+			restarts: 3,
+		},
+		{
+			name:          "unknown ladder",
+			sidecarConfig: sidecar(func(sidecarConfig *SidecarConfig) { sidecarConfig.Ladder = "medium" }),
+			err:           "ladder medium is not one of the ladders",
+		},
+		{
+			name:          "steps and ladder",
+			sidecarConfig: sidecar(func(sidecarConfig *SidecarConfig) { sidecarConfig.Steps = validSidecar().Steps }),
+			err:           "steps and ladder must not both be set",
+		},
+		{
+			name:          "workload ladder",
+			sidecarConfig: sidecar(func(sidecarConfig *SidecarConfig) { sidecarConfig.Guardrails.MaxCPU = "" }),
+			workload:      "large",
+			steps:         []string{"large-1", "large-2"},
+			restarts:      5,
+		},
+		{
+			name:          "workload ladder above the guardrail",
+			sidecarConfig: sidecar(func(sidecarConfig *SidecarConfig) {}),
+			workload:      "large",
+			err:           "step large-2: cpu_request 4 is above the guardrail of 2",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res, err := conf.WithLadder(testcase.sidecarConfig)
+			if err == nil && testcase.workload != "" {
+				res, err = conf.WithWorkloadLadder(res, testcase.workload)
+			}
+			if testcase.err != "" {
+				assert.ErrorContains(t, err, testcase.err)
+				return
+			}
+			assert.NoError(t, err)
+			var names []string
+			for _, step := range res.Steps {
+				names = append(names, step.Name)
+				assert.Equal(t, testcase.restarts, step.RestartLimit)
+			}
+			assert.Equal(t, testcase.steps, names)
+			assert.Equal(t, 5, conf.Ladders["small"][1].RestartLimit, "the ladders must not change")
+			withSidecar := conf
+			withSidecar.Sidecars = map[string]SidecarConfig{"test-sidecar": res}
+			assert.NoError(t, withSidecar.Validate())
+		})
+	}
+}
+
+func TestCompiledBounded(t *testing.T) {
+	conf := Config{Sidecars: map[string]SidecarConfig{}}
+	for i := 0; i < 2*maxCompiled; i++ {
+		key := fmt.Sprintf("sidecar-%d", i)
+		conf.Sidecars[key] = SidecarConfig{ContainerRegex: fmt.Sprintf("sidecar-%d-.*", i)}
+		assert.True(t, conf.MatchesContainer(key, key+"-a"))
+		assert.False(t, conf.MatchesContainer(key, "other"))
+	}
+	compiled.mu.Lock()
+	defer compiled.mu.Unlock()
+	assert.LessOrEqual(t, len(compiled.entries), maxCompiled)
+}
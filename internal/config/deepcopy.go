@@ -0,0 +1,30 @@
+package config
+
+import "maps"
+
+// DeepCopyInto copies the sidecar config so that the copy shares no slices or maps with it,
+// for custom resources that embed it.
+func (in *SidecarConfig) DeepCopyInto(out *SidecarConfig) {
+	*out = *in
+	out.NamespaceSelector = in.NamespaceSelector.DeepCopy()
+	out.PodSelector = in.PodSelector.DeepCopy()
+	if in.ErrCodes != nil {
+		out.ErrCodes = append([]int(nil), in.ErrCodes...)
+	}
+	if in.Steps != nil {
+		out.Steps = append([]ResourceStep(nil), in.Steps...)
+	}
+	if in.Floors != nil {
+		out.Floors = append([]FloorRule(nil), in.Floors...)
+	}
+	if in.NodePoolLadders != nil {
+		out.NodePoolLadders = make([]NodePoolLadder, len(in.NodePoolLadders))
+		for i, ladder := range in.NodePoolLadders {
+			out.NodePoolLadders[i] = NodePoolLadder{
+				Name:         ladder.Name,
+				NodeSelector: maps.Clone(ladder.NodeSelector),
+				Steps:        append([]ResourceStep(nil), ladder.Steps...),
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseDays parses the days of a floor rule into the weekdays it covers. empty covers every day.
+func ParseDays(s string) (map[time.Weekday]bool, error) {
+	res := make(map[time.Weekday]bool)
+	if strings.TrimSpace(s) == "" {
+		for _, day := range weekdays {
+			res[day] = true
+		}
+		return res, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.Split(strings.TrimSpace(part), "-")
+		first, ok := weekdays[strings.ToLower(strings.TrimSpace(bounds[0]))]
+		if !ok || len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid days %s", s)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, ok = weekdays[strings.ToLower(strings.TrimSpace(bounds[1]))]
+			if !ok {
+				return nil, fmt.Errorf("invalid days %s", s)
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			res[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return res, nil
+}
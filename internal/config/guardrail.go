@@ -0,0 +1,212 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// GuardrailAction is what das does with a team ladder that goes over a cluster guardrail.
+type GuardrailAction string
+
+func (a *GuardrailAction) UnmarshalText(data []byte) error {
+	s := string(data)
+	switch s {
+	case "", string(GuardrailReject):
+		*a = GuardrailReject
+		return nil
+	case string(GuardrailClamp):
+		*a = GuardrailClamp
+		return nil
+	default:
+		return fmt.Errorf("unknown guardrail action: %s", s)
+	}
+}
+
+const (
+	// GuardrailReject does not use a team ladder that goes over the guardrail.
+	GuardrailReject GuardrailAction = "reject"
+	// GuardrailClamp brings the team ladder within the guardrail and uses it.
+	GuardrailClamp GuardrailAction = "clamp"
+)
+
+// ClusterGuardrail is the hard limits admins set for a sidecar, that team ladders have to stay within.
+type ClusterGuardrail struct {
+	// MaxCPU and MaxMemory cap every request and limit of a team ladder.
+	MaxCPU    string `json:"max_cpu"`
+	MaxMemory string `json:"max_memory"`
+	// MinRestartLimit is the lowest restart limit a team ladder can set.
+	MinRestartLimit int `json:"min_restart_limit"`
+	// AllowedErrCodes are the exit codes a team can have das act on. empty allows any.
+	AllowedErrCodes []int           `json:"allowed_err_codes"`
+	Action          GuardrailAction `json:"action"`
+}
+
+// Enforce checks a team sidecar config against the guardrail. with reject, every problem is returned as an error.
+// with clamp, the config is brought within the guardrail and what changed is returned, dropping steps that are
+// no bigger than the step before them after clamping. an error is still returned if nothing usable is left.
+// the limits cover the node pool ladders as well as the steps, and the team's own guardrails for overrides are
+// tightened to the cluster guardrail, so das/overrides and das/ladder cannot go over it either. teams cannot
+// have das adjust HPAs or ignore quotas and node capacity.
+func (g ClusterGuardrail) Enforce(s SidecarConfig) (SidecarConfig, []string, error) {
+	res := s
+	res.Guardrails = g.bound(s.Guardrails)
+	var (
+		problems    []string
+		adjustments []string
+	)
+	if s.Guardrails.AllowOverrides && res.Guardrails != s.Guardrails {
+		adjustments = append(adjustments, "tightened guardrails for overrides to the cluster guardrail")
+	}
+	if len(g.AllowedErrCodes) > 0 {
+		var allowed []int
+		for _, code := range res.ErrCodes {
+			if !slices.Contains(g.AllowedErrCodes, code) {
+				problems = append(problems, fmt.Sprintf("err code %d is not allowed", code))
+				adjustments = append(adjustments, fmt.Sprintf("removed err code %d", code))
+				continue
+			}
+			allowed = append(allowed, code)
+		}
+		res.ErrCodes = allowed
+	}
+	if res.HPAPolicy == HPAAdjust {
+		problems = append(problems, "hpa_policy adjust is not allowed")
+		adjustments = append(adjustments, "set hpa_policy to warn")
+		res.HPAPolicy = HPAWarn
+	}
+	for _, policy := range []struct {
+		name  string
+		value *ConstraintPolicy
+	}{
+		{"quota_policy", &res.QuotaPolicy},
+		{"node_capacity_policy", &res.NodeCapacityPolicy},
+	} {
+		if *policy.value != ConstraintIgnore {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("%s ignore is not allowed", policy.name))
+		adjustments = append(adjustments, fmt.Sprintf("set %s to hold", policy.name))
+		*policy.value = ConstraintHold
+	}
+
+	var ladderProblems, ladderAdjustments []string
+	res.Steps, ladderProblems, ladderAdjustments = g.enforceSteps("", s.Steps)
+	problems = append(problems, ladderProblems...)
+	adjustments = append(adjustments, ladderAdjustments...)
+	res.NodePoolLadders = slices.Clone(s.NodePoolLadders)
+	for i := range res.NodePoolLadders {
+		ladder := &res.NodePoolLadders[i]
+		ladder.Steps, ladderProblems, ladderAdjustments = g.enforceSteps(fmt.Sprintf("node pool ladder %s: ", ladder.Name), ladder.Steps)
+		problems = append(problems, ladderProblems...)
+		adjustments = append(adjustments, ladderAdjustments...)
+	}
+	if len(problems) == 0 {
+		return res, adjustments, nil
+	}
+	if g.Action != GuardrailClamp {
+		errs := make([]error, 0, len(problems))
+		for _, problem := range problems {
+			errs = append(errs, errors.New(problem))
+		}
+		return s, nil, errors.Join(errs...)
+	}
+	if len(s.ErrCodes) > 0 && len(res.ErrCodes) == 0 {
+		return s, adjustments, errors.New("none of the err codes are allowed")
+	}
+	return res, adjustments, nil
+}
+
+// enforceSteps brings a ladder within the guardrail, returning the clamped ladder with the problems found and what
+// clamping changed. steps that are no bigger than the step before them after clamping are dropped. prefix names the
+// ladder in the messages.
+func (g ClusterGuardrail) enforceSteps(prefix string, ladder []ResourceStep) ([]ResourceStep, []string, []string) {
+	ladder = slices.Clone(ladder)
+	var (
+		problems    []string
+		adjustments []string
+	)
+	for i := range ladder {
+		step := &ladder[i]
+		if step.RestartLimit < g.MinRestartLimit {
+			problems = append(problems, fmt.Sprintf("%sstep %s: restart_limit %d is below the guardrail of %d", prefix, step.Name, step.RestartLimit, g.MinRestartLimit))
+			adjustments = append(adjustments, fmt.Sprintf("%sraised restart_limit of step %s to %d", prefix, step.Name, g.MinRestartLimit))
+			step.RestartLimit = g.MinRestartLimit
+		}
+		for _, field := range []struct {
+			name  string
+			value *string
+			max   string
+		}{
+			{"cpu_request", &step.CPURequest, g.MaxCPU},
+			{"cpu_limit", &step.CPULimit, g.MaxCPU},
+			{"mem_request", &step.MemRequest, g.MaxMemory},
+			{"mem_limit", &step.MemLimit, g.MaxMemory},
+		} {
+			if !exceeds(*field.value, field.max) {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("%sstep %s: %s %s is above the guardrail of %s", prefix, step.Name, field.name, *field.value, field.max))
+			adjustments = append(adjustments, fmt.Sprintf("%sclamped %s of step %s from %s to %s", prefix, field.name, step.Name, *field.value, field.max))
+			*field.value = field.max
+		}
+	}
+	if len(problems) == 0 {
+		return ladder, nil, nil
+	}
+	var steps []ResourceStep
+	for _, step := range ladder {
+		if len(steps) > 0 && len(validateIncrease(parseQuantities(stepValues(steps[len(steps)-1])), parseQuantities(stepValues(step)), "")) > 0 {
+			adjustments = append(adjustments, fmt.Sprintf("%sdropped step %s, no bigger than step %s after clamping", prefix, step.Name, steps[len(steps)-1].Name))
+			continue
+		}
+		steps = append(steps, step)
+	}
+	return steps, problems, adjustments
+}
+
+// bound returns guardrails for overrides no looser than the cluster guardrail.
+func (g ClusterGuardrail) bound(guardrails Guardrails) Guardrails {
+	res := guardrails
+	if looser(res.MaxCPU, g.MaxCPU) {
+		res.MaxCPU = g.MaxCPU
+	}
+	if looser(res.MaxMemory, g.MaxMemory) {
+		res.MaxMemory = g.MaxMemory
+	}
+	res.MinRestartLimit = max(res.MinRestartLimit, g.MinRestartLimit)
+	return res
+}
+
+func stepValues(step ResourceStep) map[string]string {
+	return map[string]string{"cpu_request": step.CPURequest, "cpu_limit": step.CPULimit, "mem_request": step.MemRequest, "mem_limit": step.MemLimit}
+}
+
+// looser reports whether value caps less than max. a value that is not set or does not parse caps nothing.
+func looser(value string, max string) bool {
+	if max == "" {
+		return false
+	}
+	if _, err := resource.ParseQuantity(value); err != nil {
+		return true
+	}
+	return exceeds(value, max)
+}
+
+// exceeds reports whether value is more than max. values that are not set or do not parse never exceed.
+func exceeds(value string, max string) bool {
+	if value == "" || max == "" {
+		return false
+	}
+	v, err := resource.ParseQuantity(value)
+	if err != nil {
+		return false
+	}
+	m, err := resource.ParseQuantity(max)
+	if err != nil {
+		return false
+	}
+	return v.Cmp(m) > 0
+}
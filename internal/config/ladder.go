@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// LadderAdjustment tailors a ladder from the config's ladders to a sidecar.
+type LadderAdjustment struct {
+	// StartStep drops the steps below it.
+	StartStep string `json:"start_step"`
+	// MaxStep drops the steps above it.
+	MaxStep string `json:"max_step"`
+	// RestartLimit replaces the restart limit of every step.
+	RestartLimit int `json:"restart_limit"`
+}
+
+// ladderKeys stands in for a sidecar when validating the config's ladders, which are not tied to annotation keys.
+var ladderKeys = SidecarConfig{CPUAnnotationKey: "cpu", CPULimitAnnotationKey: "cpu_limit", MemAnnotationKey: "mem", MemLimitAnnotationKey: "mem_limit"}
+
+// WithLadder fills in the steps of a sidecar config referencing one of the config's ladders, with its adjustment.
+func (c Config) WithLadder(s SidecarConfig) (SidecarConfig, error) {
+	if s.Ladder == "" {
+		return s, nil
+	}
+	if len(s.Steps) > 0 {
+		return s, errors.New("steps and ladder must not both be set")
+	}
+	steps, err := c.ladderSteps(s, s.Ladder)
+	if err != nil {
+		return s, err
+	}
+	res := s
+	res.Steps = steps
+	return res, nil
+}
+
+// WithWorkloadLadder swaps the steps of a sidecar config for a ladder an owner picked for itself, with the sidecar's
+// adjustment. like overrides, it has to be allowed by the sidecar's guardrails and stay within them.
+func (c Config) WithWorkloadLadder(s SidecarConfig, name string) (SidecarConfig, error) {
+	if !s.Guardrails.AllowOverrides {
+		return s, errors.New("overrides are not allowed for this sidecar")
+	}
+	steps, err := c.ladderSteps(s, name)
+	if err != nil {
+		return s, err
+	}
+	if errs := s.Guardrails.check(steps); len(errs) > 0 {
+		return s, errors.Join(errs...)
+	}
+	res := s
+	res.Steps = steps
+	return res, nil
+}
+
+func (c Config) ladderSteps(s SidecarConfig, name string) ([]ResourceStep, error) {
+	ladder, ok := c.Ladders[name]
+	if !ok {
+		return nil, fmt.Errorf("ladder %s is not one of the ladders", name)
+	}
+	adjustment := s.LadderAdjustment
+	steps, errs := trimSteps(slices.Clone(ladder), adjustment.StartStep, adjustment.MaxStep, adjustment.RestartLimit)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("ladder %s: %w", name, errors.Join(errs...))
+	}
+	return steps, nil
+}
+
+// withLadders fills in the steps of every sidecar in the config referencing a ladder. references to ladders that
+// do not exist are left to Validate, and every other problem is returned joined together.
+func (c *Config) withLadders() error {
+	var errs []error
+	for _, key := range sortedKeys(c.Sidecars) {
+		if _, ok := c.Ladders[c.Sidecars[key].Ladder]; c.Sidecars[key].Ladder != "" && !ok {
+			continue
+		}
+		sidecarConfig, err := c.WithLadder(c.Sidecars[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sidecar %s: %w", key, err))
+			continue
+		}
+		c.Sidecars[key] = sidecarConfig
+	}
+	return errors.Join(errs...)
+}
+
+func (c Config) validateLadders() []error {
+	var errs []error
+	for _, name := range sortedKeys(c.Ladders) {
+		for _, err := range validateSteps(ladderKeys, c.Ladders[name]) {
+			errs = append(errs, fmt.Errorf("ladder %s: %w", name, err))
+		}
+	}
+	return errs
+}
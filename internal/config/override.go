@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Override is what an owner can change about a sidecar config for itself, set in the das/overrides annotation
+// keyed by container name.
+type Override struct {
+	// StartStep drops the steps below it, so das never goes lower for the owner.
+	StartStep string `json:"start_step"`
+	// MaxStep drops the steps above it.
+	MaxStep string `json:"max_step"`
+	// RestartLimit replaces the restart limit of every step.
+	RestartLimit int `json:"restart_limit"`
+	// Steps replaces the ladder. StartStep and MaxStep are then names of these steps.
+	Steps []ResourceStep `json:"steps"`
+}
+
+// Guardrails bound what owners can override for a sidecar. without AllowOverrides, overrides are ignored.
+type Guardrails struct {
+	AllowOverrides   bool `json:"allow_overrides"`
+	AllowCustomSteps bool `json:"allow_custom_steps"`
+	// MaxCPU and MaxMemory cap every request and limit of an overridden ladder.
+	MaxCPU    string `json:"max_cpu"`
+	MaxMemory string `json:"max_memory"`
+	// MinRestartLimit is the lowest restart limit an override can set.
+	MinRestartLimit int `json:"min_restart_limit"`
+}
+
+// WithOverride merges an override into the sidecar config within its guardrails.
+// an override breaking any guardrail is rejected as a whole, with every problem returned.
+func (s SidecarConfig) WithOverride(o Override) (SidecarConfig, error) {
+	guardrails := s.Guardrails
+	if !guardrails.AllowOverrides {
+		return s, errors.New("overrides are not allowed for this sidecar")
+	}
+	res := s
+	res.Steps = slices.Clone(s.Steps)
+	var errs []error
+	if len(o.Steps) > 0 {
+		if !guardrails.AllowCustomSteps {
+			return s, errors.New("custom steps are not allowed for this sidecar")
+		}
+		res.Steps = slices.Clone(o.Steps)
+		errs = append(errs, validateSteps(res, res.Steps)...)
+	}
+	steps, trimErrs := trimSteps(res.Steps, o.StartStep, o.MaxStep, o.RestartLimit)
+	res.Steps = steps
+	errs = append(errs, trimErrs...)
+	errs = append(errs, guardrails.check(res.Steps)...)
+	if len(errs) > 0 {
+		return s, errors.Join(errs...)
+	}
+	return res, nil
+}
+
+// trimSteps drops the steps below startStep and above maxStep, and sets the restart limit of every step when it is not 0.
+// steps is changed in place.
+func trimSteps(steps []ResourceStep, startStep string, maxStep string, restartLimit int) ([]ResourceStep, []error) {
+	var errs []error
+	if startStep != "" {
+		i := slices.IndexFunc(steps, func(step ResourceStep) bool { return step.Name == startStep })
+		if i == -1 {
+			errs = append(errs, fmt.Errorf("start_step %s is not one of the steps", startStep))
+		} else {
+			steps = steps[i:]
+		}
+	}
+	if maxStep != "" {
+		i := slices.IndexFunc(steps, func(step ResourceStep) bool { return step.Name == maxStep })
+		if i == -1 {
+			errs = append(errs, fmt.Errorf("max_step %s is not one of the steps at or above the start step", maxStep))
+		} else {
+			steps = steps[:i+1]
+		}
+	}
+	if restartLimit != 0 {
+		for i := range steps {
+			steps[i].RestartLimit = restartLimit
+		}
+	}
+	return steps, errs
+}
+
+func (g Guardrails) check(steps []ResourceStep) []error {
+	var errs []error
+	for _, step := range steps {
+		if step.RestartLimit < g.MinRestartLimit {
+			errs = append(errs, fmt.Errorf("step %s: restart_limit %d is below the guardrail of %d", step.Name, step.RestartLimit, g.MinRestartLimit))
+		}
+		caps := []struct {
+			field string
+			value string
+			max   string
+		}{
+			{"cpu_request", step.CPURequest, g.MaxCPU},
+			{"cpu_limit", step.CPULimit, g.MaxCPU},
+			{"mem_request", step.MemRequest, g.MaxMemory},
+			{"mem_limit", step.MemLimit, g.MaxMemory},
+		}
+		for _, c := range caps {
+			if exceeds(c.value, c.max) {
+				errs = append(errs, fmt.Errorf("step %s: %s %s is above the guardrail of %s", step.Name, c.field, c.value, c.max))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// maxCompiled bounds the compiled regexes and templates kept. it is well above what one config uses, so the cache
+// is only dropped after reloads and sidecar policies have left it with patterns no config uses anymore.
+const maxCompiled = 512
+
+// compiled caches the regexes and templates in the config, as containers are matched on every reconcile.
+var compiled = &patternCache{entries: make(map[string]any)}
+
+type patternCache struct {
+	mu      sync.Mutex
+	entries map[string]any
+}
+
+func (p *patternCache) load(key string) (any, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	value, ok := p.entries[key]
+	return value, ok
+}
+
+// store adds to the cache, starting over when it is full.
+func (p *patternCache) store(key string, value any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.entries) >= maxCompiled {
+		p.entries = make(map[string]any)
+	}
+	p.entries[key] = value
+}
+
+// MatchesContainer reports whether the sidecar config under key is for the container. container can be a glob
+// like log-shipper-*, and container_regex a regex that has to match the whole container name.
+func (c Config) MatchesContainer(key string, containerName string) bool {
+	sidecarConfig := c.Sidecars[key]
+	if sidecarConfig.ContainerRegex != "" {
+		re, err := compileRegex(sidecarConfig.ContainerRegex)
+		if err != nil {
+			return false
+		}
+		return re.MatchString(containerName)
+	}
+	matched, err := path.Match(c.ContainerName(key), containerName)
+	return err == nil && matched
+}
+
+// IsPattern reports whether the sidecar config under key matches containers by pattern rather than by name.
+func (c Config) IsPattern(key string) bool {
+	return c.Sidecars[key].ContainerRegex != "" || strings.ContainsAny(c.ContainerName(key), `*?[\`)
+}
+
+// ForContainer fills in the container name in annotation keys written as templates, like {{.Container}}/cpu.
+func (s SidecarConfig) ForContainer(containerName string) (SidecarConfig, error) {
+	res := s
+	for _, key := range []*string{&res.CPUAnnotationKey, &res.CPULimitAnnotationKey, &res.MemAnnotationKey, &res.MemLimitAnnotationKey} {
+		if !strings.Contains(*key, "{{") {
+			continue
+		}
+		rendered, err := renderKey(*key, containerName)
+		if err != nil {
+			return s, err
+		}
+		*key = rendered
+	}
+	return res, nil
+}
+
+func renderKey(key string, containerName string) (string, error) {
+	tmpl, err := compileTemplate(key)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, struct{ Container string }{Container: containerName})
+	if err != nil {
+		return "", fmt.Errorf("error rendering annotation key %s: %w", key, err)
+	}
+	return sb.String(), nil
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := compiled.load("regex:" + expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid container_regex %s: %w", expr, err)
+	}
+	compiled.store("regex:"+expr, re)
+	return re, nil
+}
+
+func compileTemplate(key string) (*template.Template, error) {
+	if tmpl, ok := compiled.load("template:" + key); ok {
+		return tmpl.(*template.Template), nil
+	}
+	tmpl, err := template.New(key).Option("missingkey=error").Parse(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation key template %s: %w", key, err)
+	}
+	compiled.store("template:"+key, tmpl)
+	return tmpl, nil
+}
+
+// validateContainer checks the container pattern or regex and that templated annotation keys render.
+func validateContainer(containerName string, sidecarConfig SidecarConfig) []error {
+	var errs []error
+	if sidecarConfig.ContainerRegex != "" {
+		if sidecarConfig.Container != "" {
+			errs = append(errs, errors.New("container and container_regex must not both be set"))
+		}
+		if _, err := compileRegex(sidecarConfig.ContainerRegex); err != nil {
+			errs = append(errs, err)
+		}
+	} else if _, err := path.Match(containerName, ""); err != nil {
+		errs = append(errs, fmt.Errorf("invalid container pattern: %w", err))
+	}
+	if _, err := sidecarConfig.ForContainer("test-container"); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
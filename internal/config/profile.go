@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Profile names a built-in sidecar config for a well known injector.
+type Profile string
+
+const (
+	ProfileIstio   Profile = "istio"
+	ProfileLinkerd Profile = "linkerd"
+	ProfileVault   Profile = "vault"
+	// ProfileDatadog has no container name or annotation keys, as they depend on how the agent is injected.
+	// they have to be set in the config.
+	ProfileDatadog Profile = "datadog"
+)
+
+// profiles fill in what a sidecar config leaves empty. every profile acts on oom kills.
+var profiles = map[Profile]SidecarConfig{
+	ProfileIstio: {
+		Container:             "istio-proxy",
+		ErrCodes:              []int{137},
+		Owner:                 Deployment,
+		CPUAnnotationKey:      "sidecar.istio.io/proxyCPU",
+		CPULimitAnnotationKey: "sidecar.istio.io/proxyCPULimit",
+		MemAnnotationKey:      "sidecar.istio.io/proxyMemory",
+		MemLimitAnnotationKey: "sidecar.istio.io/proxyMemoryLimit",
+		Steps: []ResourceStep{
+			{Name: "small", RestartLimit: 3, CPURequest: "100m", CPULimit: "500m", MemRequest: "128Mi", MemLimit: "256Mi"},
+			{Name: "medium", RestartLimit: 3, CPURequest: "250m", CPULimit: "1", MemRequest: "256Mi", MemLimit: "512Mi"},
+			{Name: "large", RestartLimit: 3, CPURequest: "500m", CPULimit: "2", MemRequest: "512Mi", MemLimit: "1Gi"},
+		},
+	},
+	ProfileLinkerd: {
+		Container:             "linkerd-proxy",
+		ErrCodes:              []int{137},
+		Owner:                 Deployment,
+		CPUAnnotationKey:      "config.linkerd.io/proxy-cpu-request",
+		CPULimitAnnotationKey: "config.linkerd.io/proxy-cpu-limit",
+		MemAnnotationKey:      "config.linkerd.io/proxy-memory-request",
+		MemLimitAnnotationKey: "config.linkerd.io/proxy-memory-limit",
+		Steps: []ResourceStep{
+			{Name: "small", RestartLimit: 3, CPURequest: "100m", CPULimit: "500m", MemRequest: "64Mi", MemLimit: "128Mi"},
+			{Name: "medium", RestartLimit: 3, CPURequest: "250m", CPULimit: "1", MemRequest: "128Mi", MemLimit: "256Mi"},
+			{Name: "large", RestartLimit: 3, CPURequest: "500m", CPULimit: "2", MemRequest: "256Mi", MemLimit: "512Mi"},
+		},
+	},
+	ProfileVault: {
+		Container:             "vault-agent",
+		ErrCodes:              []int{137},
+		Owner:                 Deployment,
+		CPUAnnotationKey:      "vault.hashicorp.com/agent-requests-cpu",
+		CPULimitAnnotationKey: "vault.hashicorp.com/agent-limits-cpu",
+		MemAnnotationKey:      "vault.hashicorp.com/agent-requests-mem",
+		MemLimitAnnotationKey: "vault.hashicorp.com/agent-limits-mem",
+		Steps: []ResourceStep{
+			{Name: "small", RestartLimit: 3, CPURequest: "250m", CPULimit: "500m", MemRequest: "64Mi", MemLimit: "128Mi"},
+			{Name: "medium", RestartLimit: 3, CPURequest: "500m", CPULimit: "1", MemRequest: "128Mi", MemLimit: "256Mi"},
+			{Name: "large", RestartLimit: 3, CPURequest: "1", CPULimit: "2", MemRequest: "256Mi", MemLimit: "512Mi"},
+		},
+	},
+	ProfileDatadog: {
+		ErrCodes: []int{137},
+		Owner:    Deployment,
+		Steps: []ResourceStep{
+			{Name: "small", RestartLimit: 3, CPURequest: "200m", CPULimit: "500m", MemRequest: "256Mi", MemLimit: "512Mi"},
+			{Name: "medium", RestartLimit: 3, CPURequest: "500m", CPULimit: "1", MemRequest: "512Mi", MemLimit: "1Gi"},
+			{Name: "large", RestartLimit: 3, CPURequest: "1", CPULimit: "2", MemRequest: "1Gi", MemLimit: "2Gi"},
+		},
+	},
+}
+
+// WithProfile fills in what the sidecar config leaves empty from its profile. annotation keys are filled in
+// together, so setting any of them keeps the profile's keys out.
+func (s SidecarConfig) WithProfile() (SidecarConfig, error) {
+	if s.Profile == "" {
+		return s, nil
+	}
+	profile, ok := profiles[s.Profile]
+	if !ok {
+		return s, fmt.Errorf("unknown profile %s", s.Profile)
+	}
+	res := s
+	if res.Container == "" {
+		res.Container = profile.Container
+	}
+	if len(res.ErrCodes) == 0 {
+		res.ErrCodes = slices.Clone(profile.ErrCodes)
+	}
+	if res.Owner == "" {
+		res.Owner = profile.Owner
+	}
+	if len(res.Steps) == 0 {
+		res.Steps = slices.Clone(profile.Steps)
+	}
+	if res.CPUAnnotationKey == "" && res.CPULimitAnnotationKey == "" && res.MemAnnotationKey == "" && res.MemLimitAnnotationKey == "" {
+		res.CPUAnnotationKey = profile.CPUAnnotationKey
+		res.CPULimitAnnotationKey = profile.CPULimitAnnotationKey
+		res.MemAnnotationKey = profile.MemAnnotationKey
+		res.MemLimitAnnotationKey = profile.MemLimitAnnotationKey
+	}
+	return res, nil
+}
+
+// withProfiles fills in every sidecar in the config from its profile.
+func (c *Config) withProfiles() error {
+	for _, key := range sortedKeys(c.Sidecars) {
+		sidecarConfig, err := c.Sidecars[key].WithProfile()
+		if err != nil {
+			return fmt.Errorf("sidecar %s: %w", key, err)
+		}
+		c.Sidecars[key] = sidecarConfig
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const schemaID string = "https://github.com/bento01dev/das/config.schema.json"
+
+// enums are the allowed values of the config's string types. an empty value picks the default where there is one.
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(Owner("")):            {string(Deployment), string(ReplicaSet), string(DaemonSet)},
+	reflect.TypeOf(AnnotationLevel("")):  {"", string(AutoLevel), string(OwnerLevel), string(PodLevel)},
+	reflect.TypeOf(HPAPolicy("")):        {"", string(HPAWarn), string(HPAIgnore), string(HPAAdjust)},
+	reflect.TypeOf(ConstraintPolicy("")): {"", string(ConstraintHold), string(ConstraintCap), string(ConstraintIgnore)},
+	reflect.TypeOf(QoSPolicy("")):        {"", string(QoSGuaranteed), string(QoSBurstable), string(QoSPreserve)},
+	reflect.TypeOf(Profile("")):          {"", string(ProfileIstio), string(ProfileLinkerd), string(ProfileVault), string(ProfileDatadog)},
+	reflect.TypeOf(GuardrailAction("")):  {"", string(GuardrailReject), string(GuardrailClamp)},
+}
+
+// Schema generates a JSON Schema for Config from its json tags, for editors and CI to validate configs against.
+// it only covers the shape of the config. Validate still needs to run for the checks across fields.
+func Schema() ([]byte, error) {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = schemaID
+	schema["title"] = "das config"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if values, ok := enums[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		properties := make(map[string]any)
+		addProperties(t, properties)
+		return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+func addProperties(t reflect.Type, properties map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addProperties(field.Type, properties)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+	}
+}
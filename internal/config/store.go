@@ -0,0 +1,86 @@
+package config
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the config in use. the reconciler and the modifier share one, so a reload is seen by both at once.
+// the config in use is the base config from the config file with the sidecars from any other source, like
+// sidecar policies, added on top. sidecars in the base config take precedence.
+type Store struct {
+	mu      sync.Mutex
+	base    Config
+	overlay map[string]SidecarConfig
+	current atomic.Pointer[Config]
+	reloads []chan struct{}
+}
+
+func NewStore(conf Config) *Store {
+	s := &Store{}
+	s.Swap(conf)
+	return s
+}
+
+// Load returns the config in use. callers should load once per unit of work so they see a single config throughout.
+func (s *Store) Load() Config {
+	return *s.current.Load()
+}
+
+// Swap replaces the base config and tells everyone watching for reloads.
+func (s *Store) Swap(conf Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base = conf
+	s.merge()
+	for _, reloads := range s.reloads {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Reloads returns a channel signalled whenever the base config is replaced. anything worked out from the base
+// config, like the sidecars from sidecar policies, should be worked out again. signals are not queued up, so a
+// burst of reloads may come through as one.
+func (s *Store) Reloads() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reloads := make(chan struct{}, 1)
+	s.reloads = append(s.reloads, reloads)
+	return reloads
+}
+
+// Base returns the base config, without the sidecars added on top.
+func (s *Store) Base() Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.base
+}
+
+// SetOverlay replaces the sidecars added on top of the base config.
+func (s *Store) SetOverlay(sidecars map[string]SidecarConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overlay = sidecars
+	s.merge()
+}
+
+func (s *Store) merge() {
+	conf := s.base
+	if len(s.overlay) > 0 {
+		conf.Sidecars = maps.Clone(s.base.Sidecars)
+		if conf.Sidecars == nil {
+			conf.Sidecars = make(map[string]SidecarConfig)
+		}
+		for name, sidecarConfig := range s.overlay {
+			if _, ok := conf.Sidecars[name]; ok {
+				continue
+			}
+			conf.Sidecars[name] = sidecarConfig
+		}
+	}
+	s.current.Store(&conf)
+}
@@ -0,0 +1,285 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Validate checks the config for mistakes that would otherwise only show up when das updates an owner.
+// every problem found is returned joined together, each pointing to the sidecar and step it is in.
+func (c Config) Validate() error {
+	var errs []error
+	names := make([]string, 0, len(c.Sidecars))
+	for name := range c.Sidecars {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		sidecarErrs := append(validateContainer(c.ContainerName(name), c.Sidecars[name]), validateSidecar(c.Sidecars[name])...)
+		if ladder := c.Sidecars[name].Ladder; ladder != "" {
+			if _, ok := c.Ladders[ladder]; !ok {
+				sidecarErrs = append(sidecarErrs, fmt.Errorf("ladder %s is not one of the ladders", ladder))
+			}
+		}
+		for _, err := range sidecarErrs {
+			errs = append(errs, fmt.Errorf("sidecar %s: %w", name, err))
+		}
+	}
+	errs = append(errs, c.validateLadders()...)
+	if c.Budget != nil {
+		for _, err := range validateQuantities(map[string]string{"cpu": c.Budget.CPU, "memory": c.Budget.Memory}) {
+			errs = append(errs, fmt.Errorf("budget: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateSidecar(sidecarConfig SidecarConfig) []error {
+	var errs []error
+	if sidecarConfig.Owner != Deployment && sidecarConfig.Owner != DaemonSet {
+		errs = append(errs, fmt.Errorf("owner must be %s or %s, got %q", Deployment, DaemonSet, sidecarConfig.Owner))
+	}
+	if sidecarConfig.CPUAnnotationKey == "" && sidecarConfig.CPULimitAnnotationKey == "" &&
+		sidecarConfig.MemAnnotationKey == "" && sidecarConfig.MemLimitAnnotationKey == "" {
+		errs = append(errs, errors.New("at least one annotation key must be set"))
+	}
+	if _, err := metav1.LabelSelectorAsSelector(sidecarConfig.NamespaceSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid namespace_selector: %w", err))
+	}
+	if _, err := metav1.LabelSelectorAsSelector(sidecarConfig.PodSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid pod_selector: %w", err))
+	}
+	errs = append(errs, validateSteps(sidecarConfig, sidecarConfig.Steps)...)
+	errs = append(errs, validateQoS(sidecarConfig)...)
+	if sidecarConfig.ImageResetStep != "" && !hasStep(sidecarConfig.Steps, sidecarConfig.ImageResetStep) {
+		errs = append(errs, fmt.Errorf("image_reset_step %s is not one of the steps", sidecarConfig.ImageResetStep))
+	}
+	for i, rule := range sidecarConfig.Floors {
+		for _, err := range validateFloor(sidecarConfig, rule) {
+			errs = append(errs, fmt.Errorf("floor %d: %w", i, err))
+		}
+	}
+	errs = append(errs, validateNodePoolLadders(sidecarConfig)...)
+	for _, err := range validateQuantities(map[string]string{"max_cpu": sidecarConfig.Guardrails.MaxCPU, "max_memory": sidecarConfig.Guardrails.MaxMemory}) {
+		errs = append(errs, fmt.Errorf("guardrails: %w", err))
+	}
+	return errs
+}
+
+// validateSteps checks a ladder: at least one step, unique names, quantities that parse, requests no more than limits,
+// annotation keys for every value set, and each step at least as big as the one before it and bigger in something.
+func validateSteps(sidecarConfig SidecarConfig, steps []ResourceStep) []error {
+	var errs []error
+	if len(steps) == 0 {
+		return []error{errors.New("steps must not be empty")}
+	}
+	seen := make(map[string]bool)
+	var previous map[string]resource.Quantity
+	for i, step := range steps {
+		if step.Name == "" {
+			errs = append(errs, fmt.Errorf("step %d: name must be set", i))
+		} else if seen[step.Name] {
+			errs = append(errs, fmt.Errorf("step %s: duplicate name", step.Name))
+		}
+		seen[step.Name] = true
+		label := step.Name
+		if label == "" {
+			label = fmt.Sprint(i)
+		}
+		var stepErrs []error
+		if step.RestartLimit < 0 {
+			stepErrs = append(stepErrs, fmt.Errorf("restart_limit must not be negative, got %d", step.RestartLimit))
+		}
+		values := stepValues(step)
+		stepErrs = append(stepErrs, validateQuantities(values)...)
+		keys := map[string]string{"cpu_request": sidecarConfig.CPUAnnotationKey, "cpu_limit": sidecarConfig.CPULimitAnnotationKey, "mem_request": sidecarConfig.MemAnnotationKey, "mem_limit": sidecarConfig.MemLimitAnnotationKey}
+		for _, field := range sortedKeys(values) {
+			if values[field] != "" && keys[field] == "" {
+				stepErrs = append(stepErrs, fmt.Errorf("%s is set but %s_annotation_key is not", field, annotationKeyPrefix(field)))
+			}
+		}
+		quantities := parseQuantities(values)
+		for _, resourceName := range []string{"cpu", "mem"} {
+			request, hasRequest := quantities[resourceName+"_request"]
+			limit, hasLimit := quantities[resourceName+"_limit"]
+			if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+				stepErrs = append(stepErrs, fmt.Errorf("%s_request %s is more than %s_limit %s", resourceName, request.String(), resourceName, limit.String()))
+			}
+		}
+		if previous != nil {
+			stepErrs = append(stepErrs, validateIncrease(previous, quantities, steps[i-1].Name)...)
+		}
+		previous = quantities
+		for _, err := range stepErrs {
+			errs = append(errs, fmt.Errorf("step %s: %w", label, err))
+		}
+	}
+	return errs
+}
+
+// validateIncrease checks a step against the one before it. only values both steps set are compared.
+func validateIncrease(previous map[string]resource.Quantity, current map[string]resource.Quantity, previousName string) []error {
+	var errs []error
+	grows := false
+	for _, field := range sortedKeys(current) {
+		p, ok := previous[field]
+		if !ok {
+			continue
+		}
+		q := current[field]
+		switch q.Cmp(p) {
+		case -1:
+			errs = append(errs, fmt.Errorf("%s %s is less than in step %s", field, q.String(), previousName))
+		case 1:
+			grows = true
+		}
+	}
+	if len(errs) == 0 && !grows {
+		errs = append(errs, fmt.Errorf("must be bigger than step %s in at least one value", previousName))
+	}
+	return errs
+}
+
+func validateQuantities(values map[string]string) []error {
+	var errs []error
+	for _, field := range sortedKeys(values) {
+		if values[field] == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(values[field]); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q is not a valid quantity: %w", field, values[field], err))
+		}
+	}
+	return errs
+}
+
+// parseQuantities parses the values that are set and valid. validateQuantities reports the rest.
+func parseQuantities(values map[string]string) map[string]resource.Quantity {
+	res := make(map[string]resource.Quantity)
+	for field, value := range values {
+		if value == "" {
+			continue
+		}
+		if q, err := resource.ParseQuantity(value); err == nil {
+			res[field] = q
+		}
+	}
+	return res
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func annotationKeyPrefix(field string) string {
+	switch field {
+	case "cpu_request":
+		return "cpu"
+	case "mem_request":
+		return "mem"
+	default:
+		return field
+	}
+}
+
+func hasStep(steps []ResourceStep, name string) bool {
+	return slices.ContainsFunc(steps, func(step ResourceStep) bool { return step.Name == name })
+}
+
+func validateFloor(sidecarConfig SidecarConfig, rule FloorRule) []error {
+	var errs []error
+	if !hasStep(sidecarConfig.Steps, rule.Step) {
+		errs = append(errs, fmt.Errorf("step %s is not one of the steps", rule.Step))
+	}
+	timesValid := true
+	for _, t := range []string{rule.Start, rule.End} {
+		if _, err := time.Parse("15:04", t); err != nil {
+			errs = append(errs, fmt.Errorf("invalid time of day %q, expected HH:MM", t))
+			timesValid = false
+		}
+	}
+	if timesValid && rule.Start == rule.End {
+		errs = append(errs, fmt.Errorf("start and end are both %s, so the window is empty", rule.Start))
+	}
+	if _, err := ParseDays(rule.Days); err != nil {
+		errs = append(errs, fmt.Errorf("%w, expected a range like Mon-Fri or a list like Sat,Sun", err))
+	}
+	if rule.Timezone != "" {
+		if _, err := time.LoadLocation(rule.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("invalid timezone %s: %w", rule.Timezone, err))
+		}
+	}
+	if rule.Lead != "" {
+		if _, err := time.ParseDuration(rule.Lead); err != nil {
+			errs = append(errs, fmt.Errorf("invalid lead %s: %w", rule.Lead, err))
+		}
+	}
+	return errs
+}
+
+func validateQoS(sidecarConfig SidecarConfig) []error {
+	var errs []error
+	for _, step := range sidecarConfig.Steps {
+		switch sidecarConfig.QoS {
+		case QoSGuaranteed:
+			if !sameQuantity(step.CPURequest, step.CPULimit) || !sameQuantity(step.MemRequest, step.MemLimit) {
+				errs = append(errs, fmt.Errorf("step %s: qos Guaranteed needs cpu and memory requests equal to limits", step.Name))
+			}
+		case QoSBurstable:
+			if step.CPURequest == "" && step.CPULimit == "" && step.MemRequest == "" && step.MemLimit == "" {
+				errs = append(errs, fmt.Errorf("step %s: qos Burstable needs at least one request or limit", step.Name))
+			}
+		}
+	}
+	return errs
+}
+
+func validateNodePoolLadders(sidecarConfig SidecarConfig) []error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i, ladder := range sidecarConfig.NodePoolLadders {
+		if ladder.Name == "" || strings.Contains(ladder.Name, "@") {
+			errs = append(errs, fmt.Errorf("node pool ladder %d: name must be set and must not contain @", i))
+			continue
+		}
+		if seen[ladder.Name] {
+			errs = append(errs, fmt.Errorf("node pool ladder %s: duplicate name", ladder.Name))
+		}
+		seen[ladder.Name] = true
+		if len(ladder.NodeSelector) == 0 {
+			errs = append(errs, fmt.Errorf("node pool ladder %s: node_selector must not be empty", ladder.Name))
+		}
+		ladderConfig := sidecarConfig
+		ladderConfig.Steps = ladder.Steps
+		for _, err := range append(validateSteps(ladderConfig, ladder.Steps), validateQoS(ladderConfig)...) {
+			errs = append(errs, fmt.Errorf("node pool ladder %s: %w", ladder.Name, err))
+		}
+	}
+	return errs
+}
+
+func sameQuantity(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	qa, err := resource.ParseQuantity(a)
+	if err != nil {
+		return false
+	}
+	qb, err := resource.ParseQuantity(b)
+	if err != nil {
+		return false
+	}
+	return qa.Cmp(qb) == 0
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets a burst of file events, like a ConfigMap update swapping its symlinks, settle into one reload.
+const reloadDelay time.Duration = 500 * time.Millisecond
+
+// Watch reloads the config file into the store whenever it changes, until ctx is done.
+// the directory is watched rather than the file, since a mounted ConfigMap is updated by swapping a symlink
+// and not by writing to the file. a config that fails to parse or validate is not put in use.
+// report is called with the result of every reload.
+func Watch(ctx context.Context, configFilePath string, store *Store, report func(err error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating watcher for config in path %s: %w", configFilePath, err)
+	}
+	defer watcher.Close()
+	dir := filepath.Dir(configFilePath)
+	err = watcher.Add(dir)
+	if err != nil {
+		return fmt.Errorf("error watching config directory %s: %w", dir, err)
+	}
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			timer.Reset(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			report(fmt.Errorf("error watching config directory %s: %w", dir, err))
+		case <-timer.C:
+			report(Reload(configFilePath, store))
+		}
+	}
+}
+
+// Reload parses and validates the config file and puts it in use, keeping the old config on any error.
+func Reload(configFilePath string, store *Store) error {
+	conf, err := Parse(configFilePath)
+	if err != nil {
+		return err
+	}
+	store.Swap(conf)
+	return nil
+}
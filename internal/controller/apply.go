@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bento01dev/das/internal/config"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const fieldManager string = "das"
+
+// applyAnnotations server side applies only the annotations das owns onto the owner under the das field manager.
+// every apply has to carry the full set of keys das owns. a key left out of an apply is removed by the api server
+// if das was its only manager, which is why the modifier works out the owned set from every container in das details
+// rather than from the containers in the current event.
+// the resource version the annotations were worked out from is sent along, so an apply over a newer owner
+// fails with a conflict instead of overwriting it.
+func (r *PodReconciler) applyAnnotations(ctx context.Context, ownerKind config.Owner, ownerNamespacedName types.NamespacedName, resourceVersion string, ownerAnnotations map[string]string, podAnnotations map[string]string) error {
+	var (
+		obj         client.Object
+		applyConfig any
+	)
+	podTemplate := corev1apply.PodTemplateSpec().WithAnnotations(podAnnotations)
+	switch ownerKind {
+	case config.Deployment:
+		obj = &appsv1.Deployment{}
+		applyConfig = appsv1apply.Deployment(ownerNamespacedName.Name, ownerNamespacedName.Namespace).
+			WithResourceVersion(resourceVersion).
+			WithAnnotations(ownerAnnotations).
+			WithSpec(appsv1apply.DeploymentSpec().WithTemplate(podTemplate))
+	case config.DaemonSet:
+		obj = &appsv1.DaemonSet{}
+		applyConfig = appsv1apply.DaemonSet(ownerNamespacedName.Name, ownerNamespacedName.Namespace).
+			WithResourceVersion(resourceVersion).
+			WithAnnotations(ownerAnnotations).
+			WithSpec(appsv1apply.DaemonSetSpec().WithTemplate(podTemplate))
+	default:
+		return fmt.Errorf("apply not supported for owner type %s", ownerKind)
+	}
+	obj.SetName(ownerNamespacedName.Name)
+	obj.SetNamespace(ownerNamespacedName.Namespace)
+
+	data, err := json.Marshal(applyConfig)
+	if err != nil {
+		return fmt.Errorf("error marshalling apply config for %s %v: %w", ownerKind, ownerNamespacedName, err)
+	}
+	return r.Patch(ctx, obj, client.RawPatch(types.ApplyPatchType, data), client.FieldOwner(fieldManager), client.ForceOwnership)
+}
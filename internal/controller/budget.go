@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/bento01dev/das/internal/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// checkBudget holds or caps step changes that would take the extra requests das has handed out across the cluster over the budget.
+// the tally is worked out from das details on every owner in the cache, so it follows owners being scaled or deleted.
+// an update that has not reached the cache yet is not counted, so concurrent step changes can go over by a little.
+func (r *PodReconciler) checkBudget(ctx context.Context, target ownerTarget, res *newAnnotations) error {
+	budget := r.conf.Load().Budget
+	if budget == nil || len(res.decisions) == 0 {
+		return nil
+	}
+	limit := budgetResources(budget)
+	if len(limit) == 0 {
+		return nil
+	}
+	granted, err := r.budgetTally(ctx)
+	if err != nil {
+		return err
+	}
+	for name, q := range limit {
+		budgetLimit.WithLabelValues(string(name)).Set(q.AsApproximateFloat64())
+	}
+	for name, q := range granted {
+		budgetGranted.WithLabelValues(string(name)).Set(q.AsApproximateFloat64())
+	}
+
+	for i := range res.decisions {
+		d := res.decisions[i]
+		fits := func(step config.ResourceStep) bool {
+			return fitsBudget(limit, granted, stepRequestDelta(d.from, step, target.replicas))
+		}
+		if d.held || fits(d.to) {
+			r.addToTally(granted, res.decisions[i], target.replicas)
+			continue
+		}
+		if budgetExempt(budget, target) {
+			budgetDecisions.WithLabelValues("exempt").Inc()
+			res.decisions[i].notes = append(res.decisions[i].notes, "over the das budget but exempt by priority class or namespace")
+			r.addToTally(granted, res.decisions[i], target.replicas)
+			continue
+		}
+		reason := fmt.Sprintf("step %s would take das over its cluster budget", d.to.Name)
+		if err := r.constrain(target, res, i, budget.Policy, reason, fits); err != nil {
+			return err
+		}
+		switch {
+		case res.decisions[i].held:
+			budgetDecisions.WithLabelValues("held").Inc()
+		case res.decisions[i].to.Name != d.to.Name:
+			budgetDecisions.WithLabelValues("capped").Inc()
+		default:
+			budgetDecisions.WithLabelValues("ignored").Inc()
+		}
+		r.addToTally(granted, res.decisions[i], target.replicas)
+	}
+	return nil
+}
+
+func (r *PodReconciler) addToTally(granted corev1.ResourceList, d decision, replicas int32) {
+	if d.held {
+		return
+	}
+	for name, q := range stepRequestDelta(d.from, d.to, replicas) {
+		total := granted[name]
+		total.Add(q)
+		granted[name] = total
+	}
+}
+
+// budgetTally adds up what das has handed out over the first step for every container in das details of deployments and daemon sets,
+// with the ladders the owners picked.
+func (r *PodReconciler) budgetTally(ctx context.Context) (corev1.ResourceList, error) {
+	conf := r.conf.Load()
+	namespaceLabels := r.allNamespaceLabels(ctx)
+	res := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.Quantity{},
+		corev1.ResourceMemory: resource.Quantity{},
+	}
+	add := func(workload config.Workload, annotations map[string]string, replicas int32) {
+		detailsStr, ok := annotations[dasDetailsKey]
+		if !ok {
+			return
+		}
+		var details map[string]dasDetail
+		if err := json.Unmarshal([]byte(detailsStr), &details); err != nil {
+			return
+		}
+		// a container tracked on several node classes runs with the largest of its steps on every pod, so it is
+		// counted once with the largest delta.
+		containers := make(map[string]corev1.ResourceList)
+		for key, detail := range details {
+			sidecarConfig, ok := sidecarForKey(conf, key, workload)
+			if !ok || len(sidecarConfig.Steps) == 0 {
+				continue
+			}
+			// the current step is looked up on the ladder the owner picked, but counted from the sidecar's own
+			// first step, so raising the start step for an owner counts against the budget too.
+			containerName, _ := splitDetailKey(key)
+			owned := ownerSidecarConfig(conf, sidecarConfig, containerName, annotations)
+			i := slices.IndexFunc(owned.Steps, func(step config.ResourceStep) bool { return step.Name == detail.Name })
+			if i == -1 {
+				continue
+			}
+			largest, ok := containers[containerName]
+			if !ok {
+				largest = make(corev1.ResourceList)
+				containers[containerName] = largest
+			}
+			for name, q := range stepRequestDelta(sidecarConfig.Steps[0], owned.Steps[i], replicas) {
+				if current, ok := largest[name]; q.Sign() <= 0 || ok && current.Cmp(q) >= 0 {
+					continue
+				}
+				largest[name] = q
+			}
+		}
+		for _, largest := range containers {
+			for name, q := range largest {
+				total := res[name]
+				total.Add(q)
+				res[name] = total
+			}
+		}
+	}
+
+	var deployments appsv1.DeploymentList
+	if err := r.List(ctx, &deployments); err != nil {
+		return nil, fmt.Errorf("error listing deployments for budget: %w", err)
+	}
+	for _, deployment := range deployments.Items {
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		add(config.Workload{NamespaceLabels: namespaceLabels[deployment.Namespace], PodLabels: deployment.Spec.Template.Labels}, deployment.Annotations, replicas)
+	}
+	var daemonSets appsv1.DaemonSetList
+	if err := r.List(ctx, &daemonSets); err != nil {
+		return nil, fmt.Errorf("error listing daemon sets for budget: %w", err)
+	}
+	for _, daemonSet := range daemonSets.Items {
+		add(config.Workload{NamespaceLabels: namespaceLabels[daemonSet.Namespace], PodLabels: daemonSet.Spec.Template.Labels}, daemonSet.Annotations, daemonSet.Status.DesiredNumberScheduled)
+	}
+	slog.Debug("das budget tally", "cpu", res.Cpu().String(), "memory", res.Memory().String())
+	return res, nil
+}
+
+func budgetResources(budget *config.BudgetConfig) corev1.ResourceList {
+	res := make(corev1.ResourceList)
+	setQuantity(res, corev1.ResourceCPU, budget.CPU)
+	setQuantity(res, corev1.ResourceMemory, budget.Memory)
+	return res
+}
+
+func budgetExempt(budget *config.BudgetConfig, target ownerTarget) bool {
+	if slices.Contains(budget.ExemptNamespaces, target.namespacedName.Namespace) {
+		return true
+	}
+	return slices.Contains(budget.ExemptPriorityClasses, target.podTemplate.Spec.PriorityClassName)
+}
+
+// stepRequestDelta is the change in cpu and memory requests across the pods moving between two steps.
+func stepRequestDelta(from config.ResourceStep, to config.ResourceStep, replicas int32) corev1.ResourceList {
+	res := make(corev1.ResourceList)
+	for name, q := range stepDelta(from, to, replicas) {
+		switch name {
+		case corev1.ResourceRequestsCPU:
+			res[corev1.ResourceCPU] = q
+		case corev1.ResourceRequestsMemory:
+			res[corev1.ResourceMemory] = q
+		}
+	}
+	return res
+}
+
+func fitsBudget(limit corev1.ResourceList, granted corev1.ResourceList, delta corev1.ResourceList) bool {
+	for name, q := range delta {
+		max, ok := limit[name]
+		if !ok || q.Sign() <= 0 {
+			continue
+		}
+		total := granted[name]
+		total.Add(q)
+		if total.Cmp(max) > 0 {
+			return false
+		}
+	}
+	return true
+}
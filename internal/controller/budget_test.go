@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestFitsBudget(t *testing.T) {
+	limit := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10"), corev1.ResourceMemory: resource.MustParse("10Gi")}
+	from := config.ResourceStep{CPURequest: "1", MemRequest: "1Gi"}
+	testcases := []struct {
+		name     string
+		granted  corev1.ResourceList
+		to       config.ResourceStep
+		replicas int32
+		expected bool
+	}{
+		{
+			name:     "fits when granted plus delta is within budget",
+			granted:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+			to:       config.ResourceStep{CPURequest: "2", MemRequest: "1Gi"},
+			replicas: 6,
+			expected: true,
+		},
+		{
+			name:     "does not fit when granted plus delta goes over",
+			granted:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("5")},
+			to:       config.ResourceStep{CPURequest: "2", MemRequest: "1Gi"},
+			replicas: 6,
+		},
+		{
+			name:     "step downs always fit",
+			granted:  corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("20Gi")},
+			to:       config.ResourceStep{CPURequest: "1", MemRequest: "512Mi"},
+			replicas: 6,
+			expected: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res := fitsBudget(limit, testcase.granted, stepRequestDelta(from, testcase.to, testcase.replicas))
+			assert.Equal(t, testcase.expected, res)
+		})
+	}
+}
+
+// TestBudgetTally checks the tally looks up steps on the ladder each owner picked, counting from the sidecar's own first step.
+func TestBudgetTally(t *testing.T) {
+	conf := config.Config{
+		Sidecars: map[string]config.SidecarConfig{"test-container": {
+			Guardrails: config.Guardrails{AllowOverrides: true},
+			Steps: []config.ResourceStep{
+				{Name: "small", RestartLimit: 1, CPURequest: "100m"},
+				{Name: "medium", RestartLimit: 1, CPURequest: "200m"},
+			},
+			NodePoolLadders: []config.NodePoolLadder{{Name: "arm", Steps: []config.ResourceStep{
+				{Name: "arm-small", RestartLimit: 1, CPURequest: "100m"},
+				{Name: "arm-large", RestartLimit: 1, CPURequest: "400m"},
+			}}},
+		}},
+		Ladders: map[string][]config.ResourceStep{"big": {
+			{Name: "xl", RestartLimit: 1, CPURequest: "1"},
+			{Name: "xxl", RestartLimit: 1, CPURequest: "2"},
+		}},
+	}
+	deployment := func(name string, replicas int32, annotations map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: name, Annotations: annotations},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		}
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		deployment("learnt", 2, map[string]string{dasDetailsKey: `{"test-container":{"name":"medium","restart_count":0}}`}),
+		deployment("laddered", 1, map[string]string{dasDetailsKey: `{"test-container":{"name":"xxl","restart_count":0}}`, ladderAnnotationKey: "big"}),
+		deployment("overridden", 1, map[string]string{dasDetailsKey: `{"test-container":{"name":"medium","restart_count":0}}`, overridesAnnotationKey: `{"test-container":{"start_step":"medium"}}`}),
+		deployment("spanning", 1, map[string]string{dasDetailsKey: `{"test-container":{"name":"medium","restart_count":0},"test-container@arm":{"name":"arm-large","restart_count":0}}`}),
+		deployment("untracked", 5, nil),
+	).Build()
+	store := config.NewStore(conf)
+	r := NewPodReconciler(c, store, NewPodOwnerModifier(store), nil, nil)
+
+	granted, err := r.budgetTally(context.Background())
+	assert.NoError(t, err)
+	// 2 x 100m for learnt, 1900m for laddered, 100m for overridden and 300m for the arm step of spanning
+	expected := resource.MustParse("2500m")
+	assert.Zero(t, expected.Cmp(*granted.Cpu()), granted.Cpu().String())
+}
+
+// TestSettleBudget puts a step change through settle against the cluster budget, with another owner already
+// holding part of it. the cost of the change is estimated from the step it ends up on.
+func TestSettleBudget(t *testing.T) {
+	replicas := int32(1)
+	other := &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{Namespace: "other", Name: "other-deployment", Annotations: map[string]string{dasDetailsKey: `{"test-container":{"name":"test-step-2","restart_count":0}}`}},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	reason := func(step string) string {
+		return "step " + step + " would take das over its cluster budget"
+	}
+	testcases := []struct {
+		name           string
+		budget         config.BudgetConfig
+		recommendation corev1.ResourceList
+		expected       dasDetail
+		cpu            any
+		events         []string
+	}{
+		{
+			name:     "hold when the budget has no room",
+			budget:   config.BudgetConfig{CPU: "150m", Policy: config.ConstraintHold},
+			expected: dasDetail{Name: "test-step-1", RestartCount: 2},
+			events:   []string{"Warning StepHeld held test-container on step test-step-1 instead of moving to test-step-2: " + reason("test-step-2")},
+		},
+		{
+			name:           "cap to the highest step the budget has room for",
+			budget:         config.BudgetConfig{CPU: "400m", Policy: config.ConstraintCap},
+			recommendation: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("800m")},
+			expected: dasDetail{
+				Name:       "test-step-3",
+				Adjustment: "capped from test-step-4: " + reason("test-step-4"),
+				Keys:       []string{"test-container/cpu", "test-container/mem"},
+			},
+			cpu: "400m",
+			events: []string{
+				"Warning StepCapped capped test-container from step test-step-4 to test-step-3: " + reason("test-step-4"),
+				"Normal StepChanged moved test-container from step test-step-1 to test-step-3, estimated monthly cost delta 21.90",
+			},
+		},
+		{
+			name:     "step up over the budget in an exempt namespace",
+			budget:   config.BudgetConfig{CPU: "150m", Policy: config.ConstraintHold, ExemptNamespaces: []string{"test"}},
+			expected: dasDetail{Name: "test-step-2", Keys: []string{"test-container/cpu", "test-container/mem"}},
+			cpu:      "200m",
+			events:   []string{"Normal StepChanged moved test-container from step test-step-1 to test-step-2, estimated monthly cost delta 7.30"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res := settleRestart(t, settleCase{
+				conf: config.Config{
+					Sidecars: map[string]config.SidecarConfig{"test-container": testSidecar(nil)},
+					Budget:   &testcase.budget,
+					Pricing:  &config.PricingConfig{CPUHour: 0.1},
+				},
+				recommendation: testcase.recommendation,
+				objects:        []client.Object{other},
+			})
+			assert.Equal(t, map[string]dasDetail{"test-container": testcase.expected}, res.details)
+			assert.Equal(t, testcase.cpu, res.podAnnotations["test-container/cpu"])
+			assert.Equal(t, testcase.events, res.events)
+		})
+	}
+}
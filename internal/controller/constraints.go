@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// constrain holds or caps the decision at index i as per the policy when its step does not fit.
+// every hold or cap is recorded as an event on the owner.
+func (r *PodReconciler) constrain(target ownerTarget, res *newAnnotations, i int, policy config.ConstraintPolicy, reason string, fits func(step config.ResourceStep) bool) error {
+	d := res.decisions[i]
+	if d.held || fits(d.to) {
+		return nil
+	}
+	switch policy {
+	case config.ConstraintIgnore:
+		slog.Warn("step change breaks constraint but policy is ignore", "container_name", d.container, "to_step", d.to.Name, "reason", reason)
+		res.decisions[i].notes = append(res.decisions[i].notes, fmt.Sprintf("ignored: %s", reason))
+		return nil
+	case config.ConstraintCap:
+		for _, step := range lowerSteps(d) {
+			if !fits(step) {
+				continue
+			}
+			r.event(target, "StepCapped", "capped %s from step %s to %s: %s", d.container, d.to.Name, step.Name, reason)
+			return r.modifier.replaceStep(res, i, step, fmt.Sprintf("capped from %s: %s", d.to.Name, reason))
+		}
+	}
+	r.event(target, "StepHeld", "held %s on step %s instead of moving to %s: %s", d.container, d.from.Name, d.to.Name, reason)
+	return r.modifier.holdStep(res, i, reason)
+}
+
+// lowerSteps are the steps strictly between the current and the chosen step of a decision, highest first.
+func lowerSteps(d decision) []config.ResourceStep {
+	steps := d.sidecarConfig.Steps
+	from := slices.IndexFunc(steps, func(step config.ResourceStep) bool { return step.Name == d.from.Name })
+	to := slices.IndexFunc(steps, func(step config.ResourceStep) bool { return step.Name == d.to.Name })
+	if to == -1 {
+		return nil
+	}
+	var res []config.ResourceStep
+	for i := to - 1; i > from; i-- {
+		res = append(res, steps[i])
+	}
+	return res
+}
+
+func (r *PodReconciler) event(target ownerTarget, reason string, messageFmt string, args ...any) {
+	if r.recorder == nil || target.object == nil {
+		return
+	}
+	r.recorder.Eventf(target.object, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
+
+func (r *PodReconciler) normalEvent(target ownerTarget, reason string, messageFmt string, args ...any) {
+	if r.recorder == nil || target.object == nil {
+		return
+	}
+	r.recorder.Eventf(target.object, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
@@ -7,35 +7,81 @@ import (
 	"os"
 	"strings"
 
+	"github.com/bento01dev/das/internal/api/v1alpha1"
 	"github.com/bento01dev/das/internal/blob"
 	"github.com/bento01dev/das/internal/config"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlmanager "sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
-func Start(conf config.Config) error {
+// Options are how das is run, beyond what is in the config.
+type Options struct {
+	// ConfigFilePath is where the config is reloaded from when it changes.
+	ConfigFilePath string
+	// SidecarPolicies adds the sidecars from SidecarPolicy and TeamSidecarPolicy custom resources to the config,
+	// with team policies bounded by SidecarGuardrails. the crds must be installed.
+	SidecarPolicies bool
+}
+
+// Start runs das with conf until the process is signalled.
+func Start(conf config.Config, opts Options) error {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 	manager, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                  scheme,
 		LeaderElection:          true,
 		LeaderElectionID:        "das-controller",
-		LeaderElectionNamespace: "das",
+		LeaderElectionNamespace: dasNamespace,
 	})
 	if err != nil {
 		return fmt.Errorf("error creating new manager with cluster config: %w", err)
 	}
-	modifier := NewPodOwnerModifier(conf)
+	store := config.NewStore(conf)
+	modifier := NewPodOwnerModifier(store)
 	storer, err := getStorer()
 	if err != nil {
 		return fmt.Errorf("error setting storer: %w", err)
 	}
 
+	reconciler := NewPodReconciler(manager.GetClient(), store, modifier, storer, manager.GetEventRecorderFor("das"))
 	err = ctrl.
 		NewControllerManagedBy(manager).
 		For(&corev1.Pod{}).
-		Complete(NewPodReconciler(manager.GetClient(), conf, modifier, storer))
+		Complete(reconciler)
 	if err != nil {
 		return fmt.Errorf("error in setting reconciler for pod: %w", err)
 	}
 
+	err = manager.Add(ctrlmanager.RunnableFunc(reconciler.RunSweeps))
+	if err != nil {
+		return fmt.Errorf("error in adding owner sweeps: %w", err)
+	}
+
+	err = manager.Add(configReloader{configFilePath: opts.ConfigFilePath, store: store})
+	if err != nil {
+		return fmt.Errorf("error in adding config reload: %w", err)
+	}
+
+	if opts.SidecarPolicies {
+		err = manager.Add(policyWatcher{cache: manager.GetCache(), store: store})
+		if err != nil {
+			return fmt.Errorf("error in adding sidecar policy watch: %w", err)
+		}
+		err = (&SidecarPolicyReconciler{Client: manager.GetClient(), store: store}).SetupWithManager(manager)
+		if err != nil {
+			return fmt.Errorf("error in setting reconciler for sidecar policies: %w", err)
+		}
+		err = (&TeamSidecarPolicyReconciler{Client: manager.GetClient(), store: store}).SetupWithManager(manager)
+		if err != nil {
+			return fmt.Errorf("error in setting reconciler for team sidecar policies: %w", err)
+		}
+	}
+
 	slog.Info("starting manager for das..")
 	return manager.Start(ctrl.SetupSignalHandler())
 }
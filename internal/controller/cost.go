@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const hoursPerMonth float64 = 730
+
+// monthlyCostDelta estimates the change in monthly cost of moving every pod of the owner between two steps, from requests.
+// for a daemon set, replicas is the number of nodes it runs on.
+func monthlyCostDelta(pricing *config.PricingConfig, from config.ResourceStep, to config.ResourceStep, replicas int32) float64 {
+	if pricing == nil {
+		return 0
+	}
+	delta := stepRequestDelta(from, to, replicas)
+	var hourly float64
+	if cpu, ok := delta[corev1.ResourceCPU]; ok {
+		hourly += cpu.AsApproximateFloat64() * pricing.CPUHour
+	}
+	if mem, ok := delta[corev1.ResourceMemory]; ok {
+		hourly += mem.AsApproximateFloat64() / (1 << 30) * pricing.GiBHour
+	}
+	return hourly * hoursPerMonth
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonthlyCostDelta(t *testing.T) {
+	pricing := &config.PricingConfig{CPUHour: 0.04, GiBHour: 0.005}
+	testcases := []struct {
+		name     string
+		pricing  *config.PricingConfig
+		from     config.ResourceStep
+		to       config.ResourceStep
+		replicas int32
+		expected float64
+	}{
+		{
+			name:     "zero without pricing",
+			from:     config.ResourceStep{CPURequest: "1"},
+			to:       config.ResourceStep{CPURequest: "2"},
+			replicas: 3,
+		},
+		{
+			name:     "cpu and memory across replicas",
+			pricing:  pricing,
+			from:     config.ResourceStep{CPURequest: "1", MemRequest: "1Gi"},
+			to:       config.ResourceStep{CPURequest: "2", MemRequest: "3Gi"},
+			replicas: 3,
+			expected: (1*0.04 + 2*0.005) * 3 * 730,
+		},
+		{
+			name:     "negative for a step down",
+			pricing:  pricing,
+			from:     config.ResourceStep{CPURequest: "2"},
+			to:       config.ResourceStep{CPURequest: "1500m"},
+			replicas: 2,
+			expected: -0.5 * 0.04 * 2 * 730,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res := monthlyCostDelta(testcase.pricing, testcase.from, testcase.to, testcase.replicas)
+			assert.InDelta(t, testcase.expected, res, 0.0001)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"log/slog"
+
+	"github.com/bento01dev/das/internal/config"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// decision is a step change worked out for a single container of an owner.
+// the decision log is the set of slog entries written for these, whether das applies them or not.
+type decision struct {
+	container string
+	// nodeClass is the node pool ladder the decision was made on, empty for the sidecar's own steps
+	nodeClass string
+	from      config.ResourceStep
+	to        config.ResourceStep
+	// previous is the das detail of the container before the decision
+	previous dasDetail
+	// restartCount is the count the container would be left with if the step change is held
+	restartCount int
+	held         bool
+	holdReason   string
+	// monthlyCostDelta is the estimated change in monthly cost across the owner's pods
+	monthlyCostDelta float64
+	// sidecarConfig is the config the decision was made with
+	sidecarConfig config.SidecarConfig
+	// notes are anything else das did or noticed while applying the decision
+	notes []string
+	// floor is set for a move on or off a schedule floor, which leaves the learnt step as it is
+	floor bool
+}
+
+func logDecisions(ownerKind config.Owner, owner types.NamespacedName, decisions []decision, suppressed bool, reason string) {
+	for _, d := range decisions {
+		slog.Info("das decision",
+			"owner_kind", ownerKind,
+			"owner_name", owner.Name,
+			"owner_namespace", owner.Namespace,
+			"container_name", d.container,
+			"node_class", d.nodeClass,
+			"from_step", d.from.Name,
+			"to_step", d.to.Name,
+			"suppressed", suppressed,
+			"reason", reason,
+			"held", d.held,
+			"hold_reason", d.holdReason,
+			"monthly_cost_delta", d.monthlyCostDelta,
+			"notes", d.notes,
+		)
+	}
+}
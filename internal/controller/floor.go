@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/bento01dev/das/internal/config"
+)
+
+// activeFloor returns the highest step of the floor rules of the sidecar that are active at the given time.
+// floor rules name steps of the sidecar's own ladder, ownSteps. when the container runs on another ladder, like a node
+// pool ladder or one the owner picked, a floor step missing from it is mapped onto the lowest step covering its values.
+// rules that do not parse or whose step cannot be found are logged and skipped.
+func activeFloor(sidecarConfig config.SidecarConfig, ownSteps []config.ResourceStep, now time.Time) (config.ResourceStep, bool) {
+	var (
+		res   config.ResourceStep
+		found bool
+		index = -1
+	)
+	for _, rule := range sidecarConfig.Floors {
+		active, err := floorActive(rule, now)
+		if err != nil {
+			slog.Warn("skipping invalid floor rule", "step_name", rule.Step, "err", err.Error())
+			continue
+		}
+		if !active {
+			continue
+		}
+		i := floorIndex(sidecarConfig.Steps, ownSteps, rule.Step)
+		if i == -1 {
+			slog.Warn("skipping floor rule. its step is on neither the ladder in use nor the sidecar's own steps", "step_name", rule.Step)
+			continue
+		}
+		if i > index {
+			res, found, index = sidecarConfig.Steps[i], true, i
+		}
+	}
+	return res, found
+}
+
+// floorIndex returns the index in steps of the floor step name, mapping a step of ownSteps onto the lowest step of
+// steps at or above its values, or the top step if none is. -1 is returned if neither ladder has the step.
+func floorIndex(steps []config.ResourceStep, ownSteps []config.ResourceStep, name string) int {
+	if i := slices.IndexFunc(steps, func(step config.ResourceStep) bool { return step.Name == name }); i != -1 {
+		return i
+	}
+	j := slices.IndexFunc(ownSteps, func(step config.ResourceStep) bool { return step.Name == name })
+	if j == -1 || len(steps) == 0 {
+		return -1
+	}
+	for i, step := range steps {
+		if stepAtOrBelow(ownSteps[j], quotaResources(step)) {
+			return i
+		}
+	}
+	return len(steps) - 1
+}
+
+func floorActive(rule config.FloorRule, now time.Time) (bool, error) {
+	loc := time.UTC
+	if rule.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(rule.Timezone)
+		if err != nil {
+			return false, fmt.Errorf("invalid timezone %s: %w", rule.Timezone, err)
+		}
+	}
+	var lead time.Duration
+	if rule.Lead != "" {
+		var err error
+		lead, err = time.ParseDuration(rule.Lead)
+		if err != nil {
+			return false, fmt.Errorf("invalid lead %s: %w", rule.Lead, err)
+		}
+	}
+	start, err := minuteOfDay(rule.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := minuteOfDay(rule.End)
+	if err != nil {
+		return false, err
+	}
+	days, err := config.ParseDays(rule.Days)
+	if err != nil {
+		return false, err
+	}
+
+	t := now.In(loc).Add(lead)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case start <= end:
+		if minute < start || minute >= end {
+			return false, nil
+		}
+	default:
+		// overnight window. the early hours belong to the window that started the day before.
+		if minute < start && minute >= end {
+			return false, nil
+		}
+		if minute < end {
+			day = (day + 6) % 7
+		}
+	}
+	return days[day], nil
+}
+
+func minuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %s: %w", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
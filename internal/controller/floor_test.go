@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFloorActive(t *testing.T) {
+	// 2024-09-16 is a monday
+	monday := func(hour, minute int) time.Time {
+		return time.Date(2024, 9, 16, hour, minute, 0, 0, time.UTC)
+	}
+	weekdayMorning := config.FloorRule{Step: "test-step-3", Days: "Mon-Fri", Start: "08:00", End: "11:00"}
+	testcases := []struct {
+		name     string
+		rule     config.FloorRule
+		now      time.Time
+		expected bool
+		err      bool
+	}{
+		{
+			name:     "active inside the window",
+			rule:     weekdayMorning,
+			now:      monday(9, 30),
+			expected: true,
+		},
+		{
+			name: "inactive after the window",
+			rule: weekdayMorning,
+			now:  monday(11, 0),
+		},
+		{
+			name: "inactive on a day outside the range",
+			rule: weekdayMorning,
+			now:  monday(9, 30).AddDate(0, 0, -1),
+		},
+		{
+			name:     "active ahead of the window with lead",
+			rule:     config.FloorRule{Step: "test-step-3", Days: "Mon-Fri", Start: "08:00", End: "11:00", Lead: "30m"},
+			now:      monday(7, 45),
+			expected: true,
+		},
+		{
+			name:     "use the configured timezone",
+			rule:     config.FloorRule{Step: "test-step-3", Start: "08:00", End: "11:00", Timezone: "America/New_York"},
+			now:      monday(13, 0),
+			expected: true,
+		},
+		{
+			name:     "overnight window counts the early hours towards the previous day",
+			rule:     config.FloorRule{Step: "test-step-3", Days: "Sun", Start: "22:00", End: "02:00"},
+			now:      monday(1, 0),
+			expected: true,
+		},
+		{
+			name:     "wrapping day range",
+			rule:     config.FloorRule{Step: "test-step-3", Days: "Sat-Mon", Start: "00:00", End: "23:59"},
+			now:      monday(12, 0),
+			expected: true,
+		},
+		{
+			name: "error on invalid time",
+			rule: config.FloorRule{Step: "test-step-3", Start: "8am", End: "11:00"},
+			now:  monday(9, 0),
+			err:  true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res, err := floorActive(testcase.rule, testcase.now)
+			assert.Equal(t, testcase.err, err != nil)
+			assert.Equal(t, testcase.expected, res)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	dasNamespace        string = "das"
+	freezeAnnotationKey string = "das/freeze"
+)
+
+// frozen reports whether the das/freeze annotation on the das namespace is set to true.
+// while frozen, das still works out decisions but does not write to any owner.
+// a missing namespace is treated as not frozen. any other error is returned so that
+// the caller does not go ahead with an update it cannot confirm is allowed.
+func (r *PodReconciler) frozen(ctx context.Context) (bool, error) {
+	var ns corev1.Namespace
+	err := r.Get(ctx, types.NamespacedName{Name: dasNamespace}, &ns)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error reading freeze annotation from namespace %s: %w", dasNamespace, err)
+	}
+	v, ok := ns.Annotations[freezeAnnotationKey]
+	if !ok {
+		return false, nil
+	}
+	frozen, err := strconv.ParseBool(v)
+	if err != nil {
+		// an unreadable value is most likely someone trying to freeze in a hurry
+		return true, nil
+	}
+	return frozen, nil
+}
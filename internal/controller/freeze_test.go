@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func dasNamespaceWith(annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: dasNamespace, Annotations: annotations}}
+}
+
+func TestFrozen(t *testing.T) {
+	testcases := []struct {
+		name      string
+		objects   []client.Object
+		getErr    error
+		expected  bool
+		expectErr bool
+	}{
+		{
+			name: "missing namespace",
+		},
+		{
+			name:    "no freeze annotation",
+			objects: []client.Object{dasNamespaceWith(nil)},
+		},
+		{
+			name:     "frozen",
+			objects:  []client.Object{dasNamespaceWith(map[string]string{freezeAnnotationKey: "true"})},
+			expected: true,
+		},
+		{
+			name:    "not frozen",
+			objects: []client.Object{dasNamespaceWith(map[string]string{freezeAnnotationKey: "false"})},
+		},
+		{
+			name:     "unparseable value is frozen",
+			objects:  []client.Object{dasNamespaceWith(map[string]string{freezeAnnotationKey: "yes please"})},
+			expected: true,
+		},
+		{
+			name:      "get error",
+			objects:   []client.Object{dasNamespaceWith(nil)},
+			getErr:    errors.New("api server unavailable"),
+			expectErr: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithObjects(testcase.objects...)
+			if testcase.getErr != nil {
+				builder = builder.WithInterceptorFuncs(interceptor.Funcs{
+					Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+						return testcase.getErr
+					},
+				})
+			}
+			r := &PodReconciler{Client: builder.Build()}
+			frozen, err := r.frozen(context.Background())
+			if testcase.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testcase.expected, frozen)
+		})
+	}
+}
+
+// TestCommitFreeze runs an update through commit against a fake api server and checks the freeze gate holds back the apply.
+func TestCommitFreeze(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		ErrCodes:         []int{137},
+		CPUAnnotationKey: "test-container/cpu",
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", RestartLimit: 1, CPURequest: "100m"},
+			{Name: "test-step-2", RestartLimit: 1, CPURequest: "200m"},
+		},
+	}
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}}
+
+	testcases := []struct {
+		name          string
+		freeze        string
+		expectApplied bool
+	}{
+		{name: "applied when not frozen", freeze: "false", expectApplied: true},
+		{name: "skipped when frozen", freeze: "true"},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{
+					Namespace:   "test",
+					Name:        "test-deployment",
+					Annotations: map[string]string{dasDetailsKey: `{"test-container":{"name":"test-step-1","restart_count":0}}`, "test-container/cpu": "100m"},
+				},
+			}
+			var (
+				applied int
+				sent    map[string]any
+			)
+			c := fake.NewClientBuilder().
+				WithObjects(deployment, dasNamespaceWith(map[string]string{freezeAnnotationKey: testcase.freeze})).
+				WithInterceptorFuncs(interceptor.Funcs{
+					Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+						applied++
+						data, err := patch.Data(obj)
+						if err != nil {
+							return err
+						}
+						return json.Unmarshal(data, &sent)
+					},
+				}).
+				Build()
+			store := config.NewStore(conf)
+			r := NewPodReconciler(c, store, NewPodOwnerModifier(store), nil, nil)
+			target := ownerTarget{
+				kind:           config.Deployment,
+				namespacedName: types.NamespacedName{Namespace: "test", Name: "test-deployment"},
+				object:         deployment,
+				replicas:       1,
+				annotations:    deployment.Annotations,
+			}
+			details := []containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}}}
+
+			res, err := r.commit(context.Background(), target, "test-app", details)
+			assert.NoError(t, err)
+			if testcase.expectApplied {
+				assert.Equal(t, 1, applied)
+				assert.Equal(t, "test-step-2", res.steps["test-container"].Name)
+				// the apply carries the resource version the update was worked out from
+				assert.NotEmpty(t, deployment.ResourceVersion)
+				assert.Equal(t, deployment.ResourceVersion, sent["metadata"].(map[string]any)["resourceVersion"])
+				return
+			}
+			assert.Equal(t, 0, applied)
+			assert.Empty(t, res.steps)
+		})
+	}
+}
+
+// TestSweepFloorFreeze checks the freeze gate holds back schedule floors too.
+func TestSweepFloorFreeze(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		CPUAnnotationKey: "test-container/cpu",
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", RestartLimit: 1, CPURequest: "100m"},
+			{Name: "test-step-2", RestartLimit: 1, CPURequest: "200m"},
+		},
+		Floors: []config.FloorRule{{Step: "test-step-2", Start: "00:00", End: "23:59"}},
+	}
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}}
+
+	for _, freeze := range []string{"false", "true"} {
+		t.Run("frozen "+freeze, func(t *testing.T) {
+			var applied int
+			c := fake.NewClientBuilder().
+				WithObjects(dasNamespaceWith(map[string]string{freezeAnnotationKey: freeze})).
+				WithInterceptorFuncs(interceptor.Funcs{
+					Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+						applied++
+						return nil
+					},
+				}).
+				Build()
+			store := config.NewStore(conf)
+			m := NewPodOwnerModifier(store)
+			m.now = func() time.Time { return time.Date(2024, 9, 16, 12, 0, 0, 0, time.UTC) }
+			r := NewPodReconciler(c, store, m, nil, nil)
+			deployment := &appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{
+					Namespace:   "test",
+					Name:        "test-deployment",
+					Annotations: map[string]string{dasDetailsKey: `{"test-container":{"name":"test-step-1","restart_count":0}}`, "test-container/cpu": "100m"},
+				},
+			}
+			target := ownerTarget{
+				kind:           config.Deployment,
+				namespacedName: types.NamespacedName{Namespace: "test", Name: "test-deployment"},
+				object:         deployment,
+				replicas:       1,
+				annotations:    deployment.Annotations,
+			}
+			r.sweepOwner(context.Background(), target, config.Workload{})
+			if freeze == "true" {
+				assert.Equal(t, 0, applied)
+				return
+			}
+			assert.Equal(t, 1, applied)
+		})
+	}
+}
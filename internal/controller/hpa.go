@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"github.com/bento01dev/das/internal/config"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileHPAs looks for HorizontalPodAutoscalers targeting the owner and, for decisions that change the sidecar's cpu request,
+// warns about them or adjusts their container resource targets for the sidecar as per the sidecar's hpa policy.
+// whatever is done is added to the notes of the decision so that it ends up in the decision log.
+// errors here are logged and noted but not returned since the owner has already been updated.
+func (r *PodReconciler) reconcileHPAs(ctx context.Context, ownerKind config.Owner, ownerNamespacedName types.NamespacedName, decisions []decision) {
+	var hpas []autoscalingv2.HorizontalPodAutoscaler
+	listed := false
+	for i := range decisions {
+		d := &decisions[i]
+		if d.held || d.sidecarConfig.HPAPolicy == config.HPAIgnore || sameRequest(d.from.CPURequest, d.to.CPURequest) {
+			continue
+		}
+		if !listed {
+			var hpaList autoscalingv2.HorizontalPodAutoscalerList
+			if err := r.List(ctx, &hpaList, client.InNamespace(ownerNamespacedName.Namespace)); err != nil {
+				slog.Error("error listing hpas for owner", "err", err.Error(), "owner_name", ownerNamespacedName.Name, "owner_namespace", ownerNamespacedName.Namespace)
+				d.notes = append(d.notes, fmt.Sprintf("could not check hpas: %s", err.Error()))
+				return
+			}
+			for _, hpa := range hpaList.Items {
+				if config.Owner(hpa.Spec.ScaleTargetRef.Kind) == ownerKind && hpa.Spec.ScaleTargetRef.Name == ownerNamespacedName.Name {
+					hpas = append(hpas, hpa)
+				}
+			}
+			listed = true
+		}
+		for j := range hpas {
+			r.reconcileHPA(ctx, &hpas[j], d)
+		}
+	}
+}
+
+func (r *PodReconciler) reconcileHPA(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, d *decision) {
+	slog.Warn("cpu request changed for container scaled by hpa", "hpa_name", hpa.Name, "hpa_namespace", hpa.Namespace, "container_name", d.container, "from_cpu_request", d.from.CPURequest, "to_cpu_request", d.to.CPURequest)
+	if d.sidecarConfig.HPAPolicy != config.HPAAdjust {
+		d.notes = append(d.notes, fmt.Sprintf("hpa %s scales on cpu utilization relative to the changed request", hpa.Name))
+		return
+	}
+
+	original := hpa.DeepCopy()
+	var adjusted []string
+	for i, metric := range hpa.Spec.Metrics {
+		if metric.Type != autoscalingv2.ContainerResourceMetricSourceType || metric.ContainerResource == nil {
+			continue
+		}
+		source := metric.ContainerResource
+		if source.Container != d.container || source.Name != corev1.ResourceCPU ||
+			source.Target.Type != autoscalingv2.UtilizationMetricType || source.Target.AverageUtilization == nil {
+			continue
+		}
+		utilization, ok := adjustedUtilization(*source.Target.AverageUtilization, d.from.CPURequest, d.to.CPURequest)
+		if !ok {
+			continue
+		}
+		adjusted = append(adjusted, fmt.Sprintf("%d%%->%d%%", *source.Target.AverageUtilization, utilization))
+		hpa.Spec.Metrics[i].ContainerResource.Target.AverageUtilization = &utilization
+	}
+	if len(adjusted) == 0 {
+		d.notes = append(d.notes, fmt.Sprintf("hpa %s has no cpu utilization target for the container to adjust", hpa.Name))
+		return
+	}
+
+	err := r.Patch(ctx, hpa, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}), client.FieldOwner(fieldManager))
+	if err != nil {
+		slog.Error("error adjusting hpa target", "err", err.Error(), "hpa_name", hpa.Name, "hpa_namespace", hpa.Namespace)
+		d.notes = append(d.notes, fmt.Sprintf("failed adjusting hpa %s: %s", hpa.Name, err.Error()))
+		return
+	}
+	d.notes = append(d.notes, fmt.Sprintf("adjusted hpa %s cpu utilization target %v", hpa.Name, adjusted))
+}
+
+// sameRequest reports whether two requests are the same quantity, however they are written.
+func sameRequest(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	qa, err := resource.ParseQuantity(a)
+	if err != nil {
+		return false
+	}
+	qb, err := resource.ParseQuantity(b)
+	if err != nil {
+		return false
+	}
+	return qa.Cmp(qb) == 0
+}
+
+// adjustedUtilization scales a utilization target so that it triggers at the same absolute cpu after the request changes.
+func adjustedUtilization(utilization int32, fromRequest string, toRequest string) (int32, bool) {
+	from, err := resource.ParseQuantity(fromRequest)
+	if err != nil || from.IsZero() {
+		return 0, false
+	}
+	to, err := resource.ParseQuantity(toRequest)
+	if err != nil || to.IsZero() {
+		return 0, false
+	}
+	adjusted := math.Round(float64(utilization) * float64(from.MilliValue()) / float64(to.MilliValue()))
+	if adjusted < 1 {
+		adjusted = 1
+	}
+	return int32(adjusted), true
+}
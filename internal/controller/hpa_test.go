@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestAdjustedUtilization(t *testing.T) {
+	testcases := []struct {
+		name        string
+		utilization int32
+		fromRequest string
+		toRequest   string
+		expected    int32
+		ok          bool
+	}{
+		{
+			name:        "halve the target when the request doubles",
+			utilization: 80,
+			fromRequest: "1",
+			toRequest:   "2",
+			expected:    40,
+			ok:          true,
+		},
+		{
+			name:        "handle milli cpu quantities",
+			utilization: 60,
+			fromRequest: "500m",
+			toRequest:   "750m",
+			expected:    40,
+			ok:          true,
+		},
+		{
+			name:        "never go below one percent",
+			utilization: 1,
+			fromRequest: "100m",
+			toRequest:   "4",
+			expected:    1,
+			ok:          true,
+		},
+		{
+			name:        "skip when the previous request is unknown",
+			utilization: 80,
+			toRequest:   "2",
+		},
+		{
+			name:        "skip when a request cannot be parsed",
+			utilization: 80,
+			fromRequest: "1",
+			toRequest:   "2cores",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res, ok := adjustedUtilization(testcase.utilization, testcase.fromRequest, testcase.toRequest)
+			assert.Equal(t, testcase.ok, ok)
+			assert.Equal(t, testcase.expected, res)
+		})
+	}
+}
+
+func TestReconcileHPAs(t *testing.T) {
+	testcases := []struct {
+		name        string
+		policy      config.HPAPolicy
+		fromRequest string
+		toRequest   string
+		notes       []string
+		utilization int32
+	}{
+		{
+			name:        "warn about an hpa scaling on the sidecar",
+			policy:      config.HPAWarn,
+			fromRequest: "500m",
+			toRequest:   "1",
+			notes:       []string{"hpa test-hpa scales on cpu utilization relative to the changed request"},
+			utilization: 80,
+		},
+		{
+			name:        "adjust the sidecar's utilization target",
+			policy:      config.HPAAdjust,
+			fromRequest: "500m",
+			toRequest:   "1",
+			notes:       []string{"adjusted hpa test-hpa cpu utilization target [80%->40%]"},
+			utilization: 40,
+		},
+		{
+			name:        "leave the hpa alone when ignored",
+			policy:      config.HPAIgnore,
+			fromRequest: "500m",
+			toRequest:   "1",
+			utilization: 80,
+		},
+		{
+			name:        "skip a request that is the same quantity written differently",
+			policy:      config.HPAAdjust,
+			fromRequest: "1",
+			toRequest:   "1000m",
+			utilization: 80,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			utilization := int32(80)
+			hpa := &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-hpa"},
+				Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+					ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{Kind: "Deployment", Name: "test-deployment"},
+					MaxReplicas:    5,
+					Metrics: []autoscalingv2.MetricSpec{{
+						Type: autoscalingv2.ContainerResourceMetricSourceType,
+						ContainerResource: &autoscalingv2.ContainerResourceMetricSource{
+							Name:      corev1.ResourceCPU,
+							Container: "test-container",
+							Target:    autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: &utilization},
+						},
+					}},
+				},
+			}
+			var patches []map[string]any
+			c := fake.NewClientBuilder().WithObjects(hpa).
+				WithInterceptorFuncs(interceptor.Funcs{
+					Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+						data, err := patch.Data(obj)
+						assert.NoError(t, err)
+						var sent map[string]any
+						assert.NoError(t, json.Unmarshal(data, &sent))
+						patches = append(patches, sent)
+						return c.Patch(ctx, obj, patch, opts...)
+					},
+				}).Build()
+			r := &PodReconciler{Client: c}
+
+			decisions := []decision{{
+				container:     "test-container",
+				sidecarConfig: config.SidecarConfig{HPAPolicy: testcase.policy},
+				from:          config.ResourceStep{Name: "test-step-1", CPURequest: testcase.fromRequest},
+				to:            config.ResourceStep{Name: "test-step-2", CPURequest: testcase.toRequest},
+			}}
+			r.reconcileHPAs(context.Background(), config.Deployment, types.NamespacedName{Namespace: "test", Name: "test-deployment"}, decisions)
+			assert.Equal(t, testcase.notes, decisions[0].notes)
+
+			var res autoscalingv2.HorizontalPodAutoscaler
+			assert.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(hpa), &res))
+			assert.Equal(t, testcase.utilization, *res.Spec.Metrics[0].ContainerResource.Target.AverageUtilization)
+			for _, sent := range patches {
+				assert.NotEmpty(t, sent["metadata"].(map[string]any)["resourceVersion"], "the patch must carry the resource version it was made against")
+			}
+			if testcase.utilization != 80 {
+				assert.Len(t, patches, 1)
+			} else {
+				assert.Empty(t, patches)
+			}
+		})
+	}
+}
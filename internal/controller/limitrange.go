@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// clampToLimitRanges clamps the step of each decision to the container limits of the namespace's limit ranges,
+// so das does not write quantities that the api server would reject pods for.
+func (r *PodReconciler) clampToLimitRanges(ctx context.Context, target ownerTarget, res *newAnnotations) error {
+	if len(res.decisions) == 0 {
+		return nil
+	}
+	var limitRanges corev1.LimitRangeList
+	if err := r.List(ctx, &limitRanges, client.InNamespace(target.namespacedName.Namespace)); err != nil {
+		return fmt.Errorf("error listing limit ranges in %s: %w", target.namespacedName.Namespace, err)
+	}
+	var items []corev1.LimitRangeItem
+	for _, limitRange := range limitRanges.Items {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type == corev1.LimitTypeContainer {
+				items = append(items, item)
+			}
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	for i := range res.decisions {
+		d := res.decisions[i]
+		if d.held {
+			continue
+		}
+		clamped, changes := clampStep(d.to, items)
+		if len(changes) == 0 {
+			continue
+		}
+		note := fmt.Sprintf("clamped to limit range: %s", strings.Join(changes, ", "))
+		r.event(target, "StepClamped", "clamped step %s for %s to the limit range in %s: %s", d.to.Name, d.container, target.namespacedName.Namespace, strings.Join(changes, ", "))
+		if err := r.modifier.replaceStep(res, i, clamped, note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clampStep fits each quantity of a step between the min and max of the limit range items,
+// then lowers limits that are too far above their requests for maxLimitRequestRatio.
+// the returned changes describe what was clamped and are empty if the step already fits.
+func clampStep(step config.ResourceStep, items []corev1.LimitRangeItem) (config.ResourceStep, []string) {
+	var changes []string
+	clamp := func(value *string, field string, name corev1.ResourceName) {
+		if *value == "" {
+			return
+		}
+		original, err := resource.ParseQuantity(*value)
+		if err != nil {
+			return
+		}
+		q := original.DeepCopy()
+		for _, item := range items {
+			if max, ok := item.Max[name]; ok && q.Cmp(max) > 0 {
+				q = max.DeepCopy()
+			}
+			if min, ok := item.Min[name]; ok && q.Cmp(min) < 0 {
+				q = min.DeepCopy()
+			}
+		}
+		// compare quantities rather than strings, so a value written as 0.5 or 1024Mi is not taken as clamped
+		if q.Cmp(original) != 0 {
+			changes = append(changes, fmt.Sprintf("%s %s->%s", field, *value, q.String()))
+			*value = q.String()
+		}
+	}
+	clamp(&step.CPURequest, "cpu_request", corev1.ResourceCPU)
+	clamp(&step.CPULimit, "cpu_limit", corev1.ResourceCPU)
+	clamp(&step.MemRequest, "mem_request", corev1.ResourceMemory)
+	clamp(&step.MemLimit, "mem_limit", corev1.ResourceMemory)
+
+	ratio := func(request string, limit *string, field string, name corev1.ResourceName) {
+		req, err := resource.ParseQuantity(request)
+		if err != nil || req.IsZero() {
+			return
+		}
+		original, err := resource.ParseQuantity(*limit)
+		if err != nil {
+			return
+		}
+		lim := original.DeepCopy()
+		for _, item := range items {
+			maxRatio, ok := item.MaxLimitRequestRatio[name]
+			if !ok {
+				continue
+			}
+			allowed := *resource.NewMilliQuantity(req.MilliValue()*maxRatio.MilliValue()/1000, req.Format)
+			if lim.Cmp(allowed) > 0 {
+				lim = allowed
+			}
+		}
+		if lim.Cmp(original) != 0 {
+			changes = append(changes, fmt.Sprintf("%s %s->%s", field, *limit, lim.String()))
+			*limit = lim.String()
+		}
+	}
+	ratio(step.CPURequest, &step.CPULimit, "cpu_limit", corev1.ResourceCPU)
+	ratio(step.MemRequest, &step.MemLimit, "mem_limit", corev1.ResourceMemory)
+
+	return step, changes
+}
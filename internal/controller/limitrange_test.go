@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestClampStep(t *testing.T) {
+	step := config.ResourceStep{Name: "test-step", CPURequest: "2", CPULimit: "4", MemRequest: "2Gi", MemLimit: "4Gi"}
+	testcases := []struct {
+		name            string
+		step            config.ResourceStep
+		items           []corev1.LimitRangeItem
+		expected        config.ResourceStep
+		expectedChanges []string
+	}{
+		{
+			name:     "leave step as is when it fits",
+			items:    []corev1.LimitRangeItem{{Max: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("8")}}},
+			expected: step,
+		},
+		{
+			name:            "clamp to max",
+			items:           []corev1.LimitRangeItem{{Max: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")}}},
+			expected:        config.ResourceStep{Name: "test-step", CPURequest: "2", CPULimit: "4", MemRequest: "2Gi", MemLimit: "2Gi"},
+			expectedChanges: []string{"mem_limit 4Gi->2Gi"},
+		},
+		{
+			name:            "clamp to min",
+			items:           []corev1.LimitRangeItem{{Min: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")}}},
+			expected:        config.ResourceStep{Name: "test-step", CPURequest: "3", CPULimit: "4", MemRequest: "2Gi", MemLimit: "4Gi"},
+			expectedChanges: []string{"cpu_request 2->3"},
+		},
+		{
+			name:            "lower limit for max limit request ratio",
+			items:           []corev1.LimitRangeItem{{MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")}}},
+			expected:        config.ResourceStep{Name: "test-step", CPURequest: "2", CPULimit: "3", MemRequest: "2Gi", MemLimit: "4Gi"},
+			expectedChanges: []string{"cpu_limit 4->3"},
+		},
+		{
+			name:     "leave non canonical values as written when they fit",
+			step:     config.ResourceStep{Name: "test-step", CPURequest: "0.5", CPULimit: "1000m", MemRequest: "1024Mi", MemLimit: "2048Mi"},
+			items:    []corev1.LimitRangeItem{{Max: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("8"), corev1.ResourceMemory: resource.MustParse("8Gi")}, MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}}},
+			expected: config.ResourceStep{Name: "test-step", CPURequest: "0.5", CPULimit: "1000m", MemRequest: "1024Mi", MemLimit: "2048Mi"},
+		},
+		{
+			name:            "rewrite only the value that is clamped",
+			step:            config.ResourceStep{Name: "test-step", CPURequest: "0.5", CPULimit: "1000m", MemRequest: "1024Mi", MemLimit: "4096Mi"},
+			items:           []corev1.LimitRangeItem{{Max: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")}}},
+			expected:        config.ResourceStep{Name: "test-step", CPURequest: "0.5", CPULimit: "1000m", MemRequest: "1024Mi", MemLimit: "2Gi"},
+			expectedChanges: []string{"mem_limit 4096Mi->2Gi"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			s := step
+			if testcase.step.Name != "" {
+				s = testcase.step
+			}
+			res, changes := clampStep(s, testcase.items)
+			assert.Equal(t, testcase.expected, res)
+			assert.Equal(t, testcase.expectedChanges, changes)
+		})
+	}
+}
+
+// TestSettleLimitRange puts a step change through settle against a limit range, on its own and with a quota
+// holding the clamped step.
+func TestSettleLimitRange(t *testing.T) {
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-limit-range"},
+		Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+			Type: corev1.LimitTypeContainer,
+			Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("300m")},
+		}}},
+	}
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-quota"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")},
+			Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("850m")},
+		},
+	}
+	clamped := "Warning StepClamped clamped step test-step-3 for test-container to the limit range in test: cpu_request 400m->300m"
+	testcases := []struct {
+		name     string
+		objects  []client.Object
+		expected dasDetail
+		cpu      any
+		events   []string
+	}{
+		{
+			name:    "clamp the step to the limit range",
+			objects: []client.Object{limitRange},
+			expected: dasDetail{
+				Name:       "test-step-3",
+				Adjustment: "clamped to limit range: cpu_request 400m->300m",
+				Keys:       []string{"test-container/cpu", "test-container/mem"},
+			},
+			cpu: "300m",
+			events: []string{
+				clamped,
+				"Normal StepChanged moved test-container from step test-step-1 to test-step-3, estimated monthly cost delta 0.00",
+			},
+		},
+		{
+			name:     "hold the clamped step when the quota has no room for it",
+			objects:  []client.Object{limitRange, quota},
+			expected: dasDetail{Name: "test-step-1", RestartCount: 2},
+			events: []string{
+				clamped,
+				"Warning StepHeld held test-container on step test-step-1 instead of moving to test-step-3: resource quota in test cannot take step test-step-3 across 1 pods",
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res := settleRestart(t, settleCase{
+				conf:           config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": testSidecar(nil)}},
+				recommendation: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("400m")},
+				objects:        testcase.objects,
+			})
+			assert.Equal(t, map[string]dasDetail{"test-container": testcase.expected}, res.details)
+			assert.Equal(t, testcase.cpu, res.podAnnotations["test-container/cpu"])
+			assert.Equal(t, testcase.events, res.events)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	budgetGranted = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "das_budget_granted",
+		Help: "extra requests das has handed out across the cluster, in cores for cpu and bytes for memory",
+	}, []string{"resource"})
+	budgetLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "das_budget_limit",
+		Help: "configured budget for extra requests, in cores for cpu and bytes for memory",
+	}, []string{"resource"})
+	budgetDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "das_budget_decisions_total",
+		Help: "step changes that would have gone over the budget, by what das did with them",
+	}, []string{"result"})
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "das_config_reloads_total",
+		Help: "config reloads after the config file changed, by whether the new config was put in use",
+	}, []string{"result"})
+	configLastReload = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "das_config_last_reload_success_timestamp_seconds",
+		Help: "unix time the config was last loaded successfully",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(budgetGranted, budgetLimit, budgetDecisions, configReloads, configLastReload)
+}
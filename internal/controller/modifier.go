@@ -4,25 +4,37 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"slices"
+	"time"
 
 	"github.com/bento01dev/das/internal/config"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
 )
 
+const dasDetailsKey string = "das/details"
+
 type newAnnotations struct {
 	ownerAnnotations map[string]string
 	podAnnotations   map[string]string
 	steps            map[string]config.ResourceStep
+	decisions        []decision
+	dasDetails       map[string]dasDetail
+	// originalOwnerAnnotations and originalPodAnnotations are copies of the annotations before any step change,
+	// kept so that a decision can be held after the fact.
+	originalOwnerAnnotations map[string]string
+	originalPodAnnotations   map[string]string
 }
 
 type PodOwnerModifier struct {
-	conf config.Config
+	conf *config.Store
+	now  func() time.Time
 }
 
-func NewPodOwnerModifier(conf config.Config) PodOwnerModifier {
-	return PodOwnerModifier{conf: conf}
+func NewPodOwnerModifier(conf *config.Store) PodOwnerModifier {
+	return PodOwnerModifier{conf: conf, now: time.Now}
 }
 
 func (p PodOwnerModifier) getOwnerDetails(pod *corev1.Pod) map[config.Owner]types.NamespacedName {
@@ -58,6 +70,13 @@ func (p PodOwnerModifier) getCurrentStep(sidecarConfig config.SidecarConfig, ste
 	return sidecarConfig.Steps[i]
 }
 
+// stepIndex is the position of the named step in the ladder, or -1 if there is no such step.
+func (p PodOwnerModifier) stepIndex(sidecarConfig config.SidecarConfig, stepName string) int {
+	return slices.IndexFunc(sidecarConfig.Steps, func(step config.ResourceStep) bool {
+		return step.Name == stepName
+	})
+}
+
 func (p PodOwnerModifier) getNextStep(sidecarConfig config.SidecarConfig, currentStep string) int {
 	res := slices.IndexFunc(sidecarConfig.Steps, func(step config.ResourceStep) bool {
 		if step.Name == currentStep {
@@ -79,18 +98,149 @@ func (p PodOwnerModifier) getNextStep(sidecarConfig config.SidecarConfig, curren
 	return res + 1
 }
 
-func (p PodOwnerModifier) matchDetails(pod *corev1.Pod) []containerDetail {
+// getRecommendedStep moves on from the next step to the first step whose requests cover the VPA recommendation.
+// without a recommendation, the next step is returned as is.
+func (p PodOwnerModifier) getRecommendedStep(sidecarConfig config.SidecarConfig, next int, recommendation corev1.ResourceList) int {
+	if len(recommendation) == 0 {
+		return next
+	}
+	i := next
+	for i < len(sidecarConfig.Steps)-1 && !stepCovers(sidecarConfig.Steps[i], recommendation) {
+		i++
+	}
+	if i != next {
+		slog.Info("skipping ahead to step covering vpa recommendation", "step_name", sidecarConfig.Steps[i].Name, "recommendation", recommendation)
+	}
+	return i
+}
+
+func stepCovers(step config.ResourceStep, recommendation corev1.ResourceList) bool {
+	for name, value := range map[corev1.ResourceName]string{corev1.ResourceCPU: step.CPURequest, corev1.ResourceMemory: step.MemRequest} {
+		recommended, ok := recommendation[name]
+		if !ok {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			// cant compare a step that doesnt parse, so dont skip past it
+			return true
+		}
+		if q.Cmp(recommended) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// matchDetails picks the sidecar config for each container of the pod, matching selectors against the pod's labels
+// and the labels of its namespace.
+func (p PodOwnerModifier) matchDetails(pod *corev1.Pod, namespaceLabels map[string]string) []containerDetail {
 	var res []containerDetail
-	for name, sidecarConfig := range p.conf.Sidecars {
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if name == containerStatus.Name {
-				res = append(res, containerDetail{sidecarConfig: sidecarConfig, containerStatus: containerStatus})
-			}
+	conf := p.conf.Load()
+	if len(conf.Sidecars) == 0 {
+		return res
+	}
+	workload := config.Workload{NamespaceLabels: namespaceLabels, PodLabels: pod.Labels}
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		key, sidecarConfig, ok := conf.SidecarFor(containerStatus.Name, workload)
+		if !ok {
+			continue
+		}
+		slog.Debug("matched sidecar config", "container_name", containerStatus.Name, "sidecar", key)
+		res = append(res, containerDetail{sidecarConfig: sidecarConfig, containerStatus: containerStatus, image: containerImage(pod, containerStatus.Name), resources: containerResources(pod, containerStatus.Name)})
+	}
+	return res
+}
+
+func containerImage(pod *corev1.Pod, containerName string) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == containerName {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+func containerResources(pod *corev1.Pod, containerName string) corev1.ResourceRequirements {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == containerName {
+			return container.Resources
+		}
+	}
+	return corev1.ResourceRequirements{}
+}
+
+// inferStep works out the step a container is on when das has no state for it, like after a manual bump
+// or a restored backup. the annotation values are used if any are set, and the container's resources otherwise.
+// it returns the largest step at or below what is running, so the first failure never steps the container down,
+// and the first step if nothing is running or no step fits under it.
+func (p PodOwnerModifier) inferStep(d containerDetail, ownerAnnotations map[string]string, podAnnotations map[string]string) config.ResourceStep {
+	current := quotaResources(p.annotatedStep(d.sidecarConfig, ownerAnnotations, podAnnotations))
+	if len(current) == 0 {
+		for name, q := range d.resources.Requests {
+			current[corev1.ResourceName("requests."+string(name))] = q
+		}
+		for name, q := range d.resources.Limits {
+			current[corev1.ResourceName("limits."+string(name))] = q
+		}
+	}
+	res := d.sidecarConfig.Steps[0]
+	for _, step := range d.sidecarConfig.Steps {
+		if stepAtOrBelow(step, current) {
+			res = step
 		}
 	}
 	return res
 }
 
+// annotatedStep reads the step values set in the sidecar's annotations, with pod template annotations taking precedence.
+func (p PodOwnerModifier) annotatedStep(sidecarConfig config.SidecarConfig, ownerAnnotations map[string]string, podAnnotations map[string]string) config.ResourceStep {
+	value := func(key string) string {
+		if key == "" {
+			return ""
+		}
+		if v, ok := podAnnotations[key]; ok {
+			return v
+		}
+		return ownerAnnotations[key]
+	}
+	return config.ResourceStep{
+		CPURequest: value(sidecarConfig.CPUAnnotationKey),
+		CPULimit:   value(sidecarConfig.CPULimitAnnotationKey),
+		MemRequest: value(sidecarConfig.MemAnnotationKey),
+		MemLimit:   value(sidecarConfig.MemLimitAnnotationKey),
+	}
+}
+
+// stepAtOrBelow reports whether every value the step shares with current is no more than current.
+// a step sharing no values with current is not comparable and does not count.
+func stepAtOrBelow(step config.ResourceStep, current corev1.ResourceList) bool {
+	compared := false
+	for name, q := range quotaResources(step) {
+		c, ok := current[name]
+		if !ok {
+			continue
+		}
+		if q.Cmp(c) > 0 {
+			return false
+		}
+		compared = true
+	}
+	return compared
+}
+
+// imageResetStep returns the step to drop back to if the container's image changed since das recorded it.
+// a ladder without the configured reset step drops back to its first step.
+func (p PodOwnerModifier) imageResetStep(sidecarConfig config.SidecarConfig, detail dasDetail, image string) (config.ResourceStep, bool) {
+	if sidecarConfig.ImageResetStep == "" || detail.Image == "" || image == "" || detail.Image == image {
+		return config.ResourceStep{}, false
+	}
+	if i := p.stepIndex(sidecarConfig, sidecarConfig.ImageResetStep); i != -1 {
+		return sidecarConfig.Steps[i], true
+	}
+	return sidecarConfig.Steps[0], true
+}
+
 func (p PodOwnerModifier) filterTerminated(details []containerDetail) []containerDetail {
 	var filtered []containerDetail
 	for _, detail := range details {
@@ -117,6 +267,7 @@ func (p PodOwnerModifier) newAnnotations(details []containerDetail, currentOwner
 		ownerAnnotations map[string]string
 		podAnnotations   map[string]string
 		steps            map[string]config.ResourceStep = make(map[string]config.ResourceStep)
+		decisions        []decision
 		err              error
 	)
 
@@ -129,22 +280,19 @@ func (p PodOwnerModifier) newAnnotations(details []containerDetail, currentOwner
 	if podAnnotations == nil {
 		podAnnotations = make(map[string]string)
 	}
+	res.originalOwnerAnnotations = maps.Clone(ownerAnnotations)
+	res.originalPodAnnotations = maps.Clone(podAnnotations)
 
 	var dasDetails = make(map[string]dasDetail)
-	dasDetailsStr, ok := currentOwnerAnnotations["das/details"]
+	dasDetailsStr, ok := currentOwnerAnnotations[dasDetailsKey]
 	if !ok {
 		for _, d := range details {
-			dasDetails[d.containerStatus.Name] = dasDetail{Name: d.sidecarConfig.Steps[0].Name, RestartCount: 1}
+			dasDetails[detailKey(d.containerStatus.Name, d.nodeClass)] = dasDetail{Name: p.inferStep(d, ownerAnnotations, podAnnotations).Name, RestartCount: 1, Image: d.image}
 		}
-		marshalledDetails, marshallErr := json.Marshal(dasDetails)
-		if err != nil {
-			slog.Error("error marshalling json for das details", "err", marshallErr.Error())
-			return res, fmt.Errorf("error marshalling json for %v: %w", dasDetails, marshallErr)
-		}
-		ownerAnnotations["das/details"] = string(marshalledDetails)
 		res.ownerAnnotations = ownerAnnotations
 		res.podAnnotations = podAnnotations
-		return res, nil
+		res.dasDetails = dasDetails
+		return res, p.setDasDetails(&res)
 	}
 
 	if unmarshalErr := json.Unmarshal([]byte(dasDetailsStr), &dasDetails); unmarshalErr != nil {
@@ -154,46 +302,344 @@ func (p PodOwnerModifier) newAnnotations(details []containerDetail, currentOwner
 	}
 
 	for _, d := range details {
-		restartDetail, ok := dasDetails[d.containerStatus.Name]
+		key := detailKey(d.containerStatus.Name, d.nodeClass)
+		restartDetail, ok := dasDetails[key]
 		if !ok {
-			slog.Debug("no existing das detail for container. adding first step", "container_name", d.containerStatus.Name, "step_name", d.sidecarConfig.Steps[0].Name, "restart_count", 1)
-			dasDetails[d.containerStatus.Name] = dasDetail{Name: d.sidecarConfig.Steps[0].Name, RestartCount: 1}
+			inferred := p.inferStep(d, ownerAnnotations, podAnnotations)
+			slog.Debug("no existing das detail for container. adding inferred step", "container_name", d.containerStatus.Name, "node_class", d.nodeClass, "step_name", inferred.Name, "restart_count", 1)
+			dasDetails[key] = dasDetail{Name: inferred.Name, RestartCount: 1, Image: d.image}
+			continue
+		}
+		if p.stepIndex(d.sidecarConfig, restartDetail.Name) == -1 {
+			// the learnt step is not on the ladder the container runs with now, like after an override raised the
+			// start step or the owner picked another ladder. map it onto the ladder from what is running and count
+			// afresh, raising the container if it runs below the ladder.
+			mapped := p.inferStep(d, ownerAnnotations, podAnnotations)
+			running := p.annotatedStep(d.sidecarConfig, ownerAnnotations, podAnnotations)
+			running.Name = restartDetail.Name
+			slog.Info("learnt step not on the ladder. mapping it onto the ladder", "container_name", d.containerStatus.Name, "node_class", d.nodeClass, "from_step", restartDetail.Name, "step_name", mapped.Name)
+			dasDetails[key] = dasDetail{Name: mapped.Name, RestartCount: 1, Image: restartDetail.Image, Keys: restartDetail.Keys, OwnerKeys: restartDetail.OwnerKeys}
+			if stepAtOrBelow(mapped, quotaResources(running)) {
+				continue
+			}
+			dasDetails[key] = withKeys(dasDetails[key], d.sidecarConfig, ownerAnnotations, podAnnotations)
+			p.setStepAnnotations(d.sidecarConfig, mapped, ownerAnnotations, podAnnotations)
+			steps[key] = mapped
+			decisions = append(decisions, decision{container: d.containerStatus.Name, nodeClass: d.nodeClass, from: running, to: mapped, previous: restartDetail, restartCount: 1, sidecarConfig: d.sidecarConfig, notes: []string{fmt.Sprintf("step %s is not on the ladder", restartDetail.Name)}})
 			continue
 		}
 		currentStep := p.getCurrentStep(d.sidecarConfig, restartDetail.Name)
+		if restartDetail.Floor != "" && p.stepIndex(d.sidecarConfig, restartDetail.Floor) > p.stepIndex(d.sidecarConfig, currentStep.Name) {
+			// a schedule floor is running above the learnt step. escalation starts from what is actually running.
+			currentStep = p.getCurrentStep(d.sidecarConfig, restartDetail.Floor)
+		}
 		if restartDetail.RestartCount+1 < currentStep.RestartLimit {
 			slog.Debug("restart count less than current step limit", "container_name", d.containerStatus.Name, "step_name", restartDetail.Name, "restart_count", restartDetail.RestartCount+1)
-			dasDetails[d.containerStatus.Name] = dasDetail{Name: restartDetail.Name, RestartCount: restartDetail.RestartCount + 1}
+			counted := restartDetail
+			counted.RestartCount++
+			dasDetails[key] = counted
 			continue
 		}
-		nextStep := d.sidecarConfig.Steps[p.getNextStep(d.sidecarConfig, restartDetail.Name)]
+		nextStep := d.sidecarConfig.Steps[p.getRecommendedStep(d.sidecarConfig, p.getNextStep(d.sidecarConfig, currentStep.Name), d.recommendation)]
 		if currentStep.Name == nextStep.Name {
 			slog.Debug("current step and next step are the same. so its in the last step. just incrementing count.", "container_name", d.containerStatus.Name, "step_name", nextStep.Name, "restart_count", restartDetail.RestartCount+1)
-			dasDetails[d.containerStatus.Name] = dasDetail{Name: nextStep.Name, RestartCount: restartDetail.RestartCount + 1}
+			dasDetails[key] = dasDetail{Name: nextStep.Name, RestartCount: restartDetail.RestartCount + 1, Image: restartDetail.Image, Keys: restartDetail.Keys, OwnerKeys: restartDetail.OwnerKeys}
 			continue
 		}
 		slog.Info("Setting next step as new step for das detail for container", "container_name", d.containerStatus.Name, "step_name", nextStep.Name)
-		dasDetails[d.containerStatus.Name] = dasDetail{Name: nextStep.Name}
-		podAnnotations[d.sidecarConfig.CPUAnnotationKey] = nextStep.CPURequest
-		podAnnotations[d.sidecarConfig.CPULimitAnnotationKey] = nextStep.CPULimit
-		podAnnotations[d.sidecarConfig.MemAnnotationKey] = nextStep.MemRequest
-		podAnnotations[d.sidecarConfig.MemLimitAnnotationKey] = nextStep.MemLimit
-		steps[d.containerStatus.Name] = nextStep
-	}
-
-	newDasDetails, marshalErr := json.Marshal(dasDetails)
-	if marshalErr != nil {
-		slog.Error("error in marhsalling new das details", "err", marshalErr)
-		err = fmt.Errorf("Error in marshalling the new das details after determining next step: %w", marshalErr)
-		return res, nil
+		dasDetails[key] = withKeys(dasDetail{Name: nextStep.Name, Image: restartDetail.Image, Keys: restartDetail.Keys, OwnerKeys: restartDetail.OwnerKeys}, d.sidecarConfig, ownerAnnotations, podAnnotations)
+		p.setStepAnnotations(d.sidecarConfig, nextStep, ownerAnnotations, podAnnotations)
+		steps[key] = nextStep
+		decisions = append(decisions, decision{container: d.containerStatus.Name, nodeClass: d.nodeClass, from: currentStep, to: nextStep, previous: restartDetail, restartCount: restartDetail.RestartCount + 1, sidecarConfig: d.sidecarConfig})
 	}
 
-	slog.Debug("setting new das details", "das_details", string(newDasDetails))
-	ownerAnnotations["das/details"] = string(newDasDetails)
-
 	res.ownerAnnotations = ownerAnnotations
 	res.podAnnotations = podAnnotations
 	res.steps = steps
+	res.decisions = decisions
+	res.dasDetails = dasDetails
+
+	return res, p.setDasDetails(&res)
+}
+
+// sweepSteps moves each container in das details onto the step it should be on right now. a container whose image
+// in images differs from the one das recorded first drops back to its image reset step, with counts reset. it then goes
+// on the highest active schedule floor if that is above the learnt step, and on the learnt step otherwise.
+// the given annotations are not modified.
+func (p PodOwnerModifier) sweepSteps(workload config.Workload, currentOwnerAnnotations map[string]string, currentPodAnnotations map[string]string, images map[string]string) (newAnnotations, error) {
+	var res newAnnotations
+	dasDetailsStr, ok := currentOwnerAnnotations[dasDetailsKey]
+	if !ok {
+		return res, nil
+	}
+	var dasDetails map[string]dasDetail
+	if err := json.Unmarshal([]byte(dasDetailsStr), &dasDetails); err != nil {
+		return res, fmt.Errorf("error parsing das details in %w", err)
+	}
+
+	res.ownerAnnotations = maps.Clone(currentOwnerAnnotations)
+	res.podAnnotations = maps.Clone(currentPodAnnotations)
+	if res.podAnnotations == nil {
+		res.podAnnotations = make(map[string]string)
+	}
+	res.originalOwnerAnnotations = maps.Clone(res.ownerAnnotations)
+	res.originalPodAnnotations = maps.Clone(res.podAnnotations)
+	res.steps = make(map[string]config.ResourceStep)
+	res.dasDetails = dasDetails
+
+	now := p.now()
+	conf := p.conf.Load()
+	keys := make([]string, 0, len(dasDetails))
+	for key := range dasDetails {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	changed := false
+	for _, key := range keys {
+		detail := dasDetails[key]
+		containerName, nodeClass := splitDetailKey(key)
+		sidecarConfig, ok := ownerSidecarForKey(conf, key, workload, currentOwnerAnnotations)
+		if !ok {
+			continue
+		}
+		learnt := p.stepIndex(sidecarConfig, detail.Name)
+		if learnt == -1 {
+			continue
+		}
+		applied := detail.Name
+		if detail.Floor != "" {
+			applied = detail.Floor
+		}
+		updated := detail
+		image := images[containerName]
+		var notes []string
+		resetStep, reset := p.imageResetStep(sidecarConfig, detail, image)
+		switch {
+		case reset:
+			// a new image can need very different resources. start learning again from the reset step.
+			slog.Info("image changed. dropping back to reset step", "container_name", containerName, "node_class", nodeClass, "from_image", detail.Image, "to_image", image, "step_name", resetStep.Name)
+			updated = dasDetail{Name: resetStep.Name, Image: image, Keys: detail.Keys, OwnerKeys: detail.OwnerKeys}
+			learnt = p.stepIndex(sidecarConfig, resetStep.Name)
+			notes = append(notes, fmt.Sprintf("image changed from %s to %s", detail.Image, image))
+			changed = true
+		case sidecarConfig.ImageResetStep != "" && detail.Image == "" && image != "":
+			// nothing to compare the next image with yet
+			updated.Image = image
+			changed = true
+		}
+
+		desired := sidecarConfig.Steps[learnt]
+		updated.Floor = ""
+		if floorStep, active := activeFloor(sidecarConfig, ownSteps(conf, containerName, workload), now); active && p.stepIndex(sidecarConfig, floorStep.Name) > learnt {
+			desired = floorStep
+			updated.Floor = floorStep.Name
+		}
+		if updated.Floor != "" || detail.Floor != "" {
+			notes = append(notes, "schedule floor")
+		}
+		if desired.Name == applied {
+			dasDetails[key] = updated
+			continue
+		}
+		slog.Info("moving container to the step it should be on", "container_name", containerName, "node_class", nodeClass, "from_step", applied, "to_step", desired.Name)
+		changed = true
+		dasDetails[key] = withKeys(updated, sidecarConfig, res.ownerAnnotations, res.podAnnotations)
+		p.setStepAnnotations(sidecarConfig, desired, res.ownerAnnotations, res.podAnnotations)
+		res.steps[key] = desired
+		res.decisions = append(res.decisions, decision{
+			container:     containerName,
+			nodeClass:     nodeClass,
+			from:          p.getCurrentStep(sidecarConfig, applied),
+			to:            desired,
+			previous:      detail,
+			restartCount:  detail.RestartCount,
+			sidecarConfig: sidecarConfig,
+			notes:         notes,
+			floor:         updated.Floor != "" || !reset,
+		})
+	}
+	if !changed {
+		return res, nil
+	}
+	return res, p.setDasDetails(&res)
+}
+
+// holdStep undoes the step change of a decision. the container stays on its current step with the restart count it
+// would have had, so the next restart tries the step change again.
+func (p PodOwnerModifier) holdStep(res *newAnnotations, i int, reason string) error {
+	d := &res.decisions[i]
+	d.held = true
+	d.holdReason = reason
+	for _, key := range annotationKeys(d.sidecarConfig) {
+		restoreAnnotation(res.ownerAnnotations, res.originalOwnerAnnotations, key)
+		restoreAnnotation(res.podAnnotations, res.originalPodAnnotations, key)
+	}
+	held := d.previous
+	held.RestartCount = d.restartCount
+	key := detailKey(d.container, d.nodeClass)
+	res.dasDetails[key] = held
+	delete(res.steps, key)
+	return p.setDasDetails(res)
+}
+
+// replaceStep swaps the step a decision moves to, for when a check caps or clamps the step das picked.
+// the note is kept in das details so the adjustment is visible on the owner.
+func (p PodOwnerModifier) replaceStep(res *newAnnotations, i int, step config.ResourceStep, note string) error {
+	d := &res.decisions[i]
+	p.setStepAnnotations(d.sidecarConfig, step, res.ownerAnnotations, res.podAnnotations)
+	key := detailKey(d.container, d.nodeClass)
+	if d.floor {
+		// the learnt step stays as it is. only the floor applied above it changes.
+		detail := withKeys(res.dasDetails[key], d.sidecarConfig, res.ownerAnnotations, res.podAnnotations)
+		detail.Floor = step.Name
+		if step.Name == detail.Name {
+			detail.Floor = ""
+		}
+		detail.Adjustment = note
+		res.dasDetails[key] = detail
+	} else {
+		res.dasDetails[key] = withKeys(dasDetail{Name: step.Name, Adjustment: note, Image: res.dasDetails[key].Image, Keys: res.dasDetails[key].Keys, OwnerKeys: res.dasDetails[key].OwnerKeys}, d.sidecarConfig, res.ownerAnnotations, res.podAnnotations)
+	}
+	res.steps[key] = step
+	d.to = step
+	d.notes = append(d.notes, note)
+	return p.setDasDetails(res)
+}
+
+// coverStep writes step to the annotations of a decision without changing the step das learnt, for when the
+// annotations have to cover more than the decision's own step.
+func (p PodOwnerModifier) coverStep(res *newAnnotations, i int, step config.ResourceStep, note string) {
+	d := &res.decisions[i]
+	p.setStepAnnotations(d.sidecarConfig, step, res.ownerAnnotations, res.podAnnotations)
+	d.notes = append(d.notes, note)
+}
+
+func (p PodOwnerModifier) setDasDetails(res *newAnnotations) error {
+	marshalled, err := json.Marshal(res.dasDetails)
+	if err != nil {
+		slog.Error("error in marshalling das details", "err", err.Error())
+		return fmt.Errorf("error in marshalling das details %v: %w", res.dasDetails, err)
+	}
+	res.ownerAnnotations[dasDetailsKey] = string(marshalled)
+	return nil
+}
 
-	return res, nil
+// withKeys adds the annotation keys of the sidecar config to the keys das has written for the container, under the
+// level das writes each of them at.
+func withKeys(detail dasDetail, sidecarConfig config.SidecarConfig, ownerAnnotations map[string]string, podAnnotations map[string]string) dasDetail {
+	keys := slices.Clone(detail.Keys)
+	ownerKeys := slices.Clone(detail.OwnerKeys)
+	for _, key := range annotationKeys(sidecarConfig) {
+		if key == "" {
+			continue
+		}
+		keys = slices.DeleteFunc(keys, func(k string) bool { return k == key })
+		ownerKeys = slices.DeleteFunc(ownerKeys, func(k string) bool { return k == key })
+		if onOwner(sidecarConfig.AnnotationLevel, key, ownerAnnotations, podAnnotations) {
+			ownerKeys = append(ownerKeys, key)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	slices.Sort(ownerKeys)
+	detail.Keys = slices.Clip(keys)
+	detail.OwnerKeys = slices.Clip(ownerKeys)
+	if len(detail.Keys) == 0 {
+		detail.Keys = nil
+	}
+	if len(detail.OwnerKeys) == 0 {
+		detail.OwnerKeys = nil
+	}
+	return detail
+}
+
+func restoreAnnotation(annotations map[string]string, original map[string]string, key string) {
+	if v, ok := original[key]; ok {
+		annotations[key] = v
+		return
+	}
+	delete(annotations, key)
+}
+
+func annotationKeys(sidecarConfig config.SidecarConfig) []string {
+	return []string{sidecarConfig.CPUAnnotationKey, sidecarConfig.CPULimitAnnotationKey, sidecarConfig.MemAnnotationKey, sidecarConfig.MemLimitAnnotationKey}
+}
+
+func (p PodOwnerModifier) setStepAnnotations(sidecarConfig config.SidecarConfig, step config.ResourceStep, ownerAnnotations map[string]string, podAnnotations map[string]string) {
+	values := map[string]string{
+		sidecarConfig.CPUAnnotationKey:      step.CPURequest,
+		sidecarConfig.CPULimitAnnotationKey: step.CPULimit,
+		sidecarConfig.MemAnnotationKey:      step.MemRequest,
+		sidecarConfig.MemLimitAnnotationKey: step.MemLimit,
+	}
+	for key, value := range values {
+		if key == "" {
+			continue
+		}
+		target := p.annotationTarget(sidecarConfig.AnnotationLevel, key, ownerAnnotations, podAnnotations)
+		target[key] = value
+	}
+}
+
+// annotationTarget returns the annotation map a key should be written to.
+// with auto, a key defined on the owner but not on the pod template is updated on the owner
+// so that das's value is not shadowed by, or shadowing, whatever set it there.
+func (p PodOwnerModifier) annotationTarget(level config.AnnotationLevel, key string, ownerAnnotations map[string]string, podAnnotations map[string]string) map[string]string {
+	if onOwner(level, key, ownerAnnotations, podAnnotations) {
+		return ownerAnnotations
+	}
+	return podAnnotations
+}
+
+// onOwner reports whether a key is written to the owner rather than the pod template.
+func onOwner(level config.AnnotationLevel, key string, ownerAnnotations map[string]string, podAnnotations map[string]string) bool {
+	switch level {
+	case config.OwnerLevel:
+		return true
+	case config.PodLevel:
+		return false
+	default:
+		_, owner := ownerAnnotations[key]
+		_, pod := podAnnotations[key]
+		return owner && !pod
+	}
+}
+
+// ownedAnnotations picks out the annotations das manages from the full owner and pod template annotations.
+// this is das details and every key das has written for any container in it, not just the ones for the containers
+// in the current event, so that an apply never drops a key das set earlier for another sidecar.
+// configured keys das has not written are left to whoever set them. keys das wrote on the owner are claimed there.
+// other keys are claimed on the pod template if set there, since that is where das writes them unless the key is
+// only on the owner. das details from before the level was recorded only have these.
+func (p PodOwnerModifier) ownedAnnotations(ownerAnnotations map[string]string, podAnnotations map[string]string) (map[string]string, map[string]string) {
+	owned := make(map[string]string)
+	ownedPod := make(map[string]string)
+	v, ok := ownerAnnotations[dasDetailsKey]
+	if !ok {
+		return owned, ownedPod
+	}
+	owned[dasDetailsKey] = v
+	var dasDetails map[string]dasDetail
+	if err := json.Unmarshal([]byte(v), &dasDetails); err != nil {
+		slog.Error("error in unmarshalling das details for owned annotations", "err", err.Error())
+		return owned, ownedPod
+	}
+	for _, detail := range dasDetails {
+		for _, key := range detail.OwnerKeys {
+			if v, ok := ownerAnnotations[key]; ok {
+				owned[key] = v
+			}
+		}
+		for _, key := range detail.Keys {
+			if v, ok := podAnnotations[key]; ok {
+				ownedPod[key] = v
+				continue
+			}
+			if v, ok := ownerAnnotations[key]; ok {
+				owned[key] = v
+			}
+		}
+	}
+	return owned, ownedPod
 }
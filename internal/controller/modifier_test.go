@@ -3,10 +3,12 @@ package controller
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/bento01dev/das/internal/config"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -108,7 +110,7 @@ func TestGetOwnerDetails(t *testing.T) {
 
 	for _, testcase := range testcases {
 		t.Run(testcase.name, func(t *testing.T) {
-			m := NewPodOwnerModifier(config.Config{})
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
 			res := m.getOwnerDetails(testcase.pod)
 			assert.Equal(t, testcase.expected, res)
 		})
@@ -146,7 +148,7 @@ func TestGetCurrentStep(t *testing.T) {
 
 	for _, testcase := range testcases {
 		t.Run(testcase.name, func(t *testing.T) {
-			m := NewPodOwnerModifier(config.Config{})
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
 			res := m.getCurrentStep(testcase.sidecarConfig, testcase.stepName)
 			assert.Equal(t, testcase.expected, res)
 		})
@@ -209,19 +211,72 @@ func TestGetNextStep(t *testing.T) {
 
 	for _, testcase := range testcases {
 		t.Run(testcase.name, func(t *testing.T) {
-			m := NewPodOwnerModifier(config.Config{})
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
 			res := m.getNextStep(testcase.sidecarConfig, testcase.currentStep)
 			assert.Equal(t, testcase.expected, res)
 		})
 	}
 }
 
+func TestGetRecommendedStep(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", CPURequest: "500m", MemRequest: "512Mi"},
+			{Name: "test-step-2", CPURequest: "1", MemRequest: "1Gi"},
+			{Name: "test-step-3", CPURequest: "2", MemRequest: "2Gi"},
+			{Name: "test-step-4", CPURequest: "4", MemRequest: "4Gi"},
+		},
+	}
+	testcases := []struct {
+		name           string
+		next           int
+		recommendation corev1.ResourceList
+		expected       int
+	}{
+		{
+			name:     "return next step without a recommendation",
+			next:     1,
+			expected: 1,
+		},
+		{
+			name:           "return next step when it covers the recommendation",
+			next:           1,
+			recommendation: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("800m")},
+			expected:       1,
+		},
+		{
+			name: "skip to the first step covering every recommended resource",
+			next: 1,
+			recommendation: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("800m"),
+				corev1.ResourceMemory: resource.MustParse("1500Mi"),
+			},
+			expected: 2,
+		},
+		{
+			name:           "stop at the last step when nothing covers the recommendation",
+			next:           1,
+			recommendation: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("8")},
+			expected:       3,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+			res := m.getRecommendedStep(sidecarConfig, testcase.next, testcase.recommendation)
+			assert.Equal(t, testcase.expected, res)
+		})
+	}
+}
+
 func TestMatchDetails(t *testing.T) {
 	testcases := []struct {
-		name     string
-		pod      *corev1.Pod
-		conf     config.Config
-		expected []containerDetail
+		name            string
+		pod             *corev1.Pod
+		namespaceLabels map[string]string
+		conf            config.Config
+		expected        []containerDetail
 	}{
 		{
 			name: "return empty list when no sidecar listed in conf",
@@ -298,12 +353,55 @@ func TestMatchDetails(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "picks the sidecar config whose selectors match",
+			pod: &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{"tier": "web"}},
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{
+						{Name: "test-container"},
+					},
+				},
+			},
+			namespaceLabels: map[string]string{"env": "prod"},
+			conf: config.Config{
+				Sidecars: map[string]config.SidecarConfig{
+					"test-container-dev":  {Container: "test-container", ErrCodes: []int{1}, NamespaceSelector: &v1.LabelSelector{MatchLabels: map[string]string{"env": "dev"}}},
+					"test-container-prod": {Container: "test-container", ErrCodes: []int{2}, NamespaceSelector: &v1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+					"test-container-web":  {Container: "test-container", ErrCodes: []int{3}, Priority: 1, PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{"tier": "web"}}},
+				},
+			},
+			expected: []containerDetail{
+				{
+					sidecarConfig: config.SidecarConfig{Container: "test-container", ErrCodes: []int{3}, Priority: 1, PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{"tier": "web"}}},
+					containerStatus: corev1.ContainerStatus{
+						Name: "test-container",
+					},
+				},
+			},
+		},
+		{
+			name: "leaves out containers in namespaces no selector matches",
+			pod: &corev1.Pod{
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{
+						{Name: "test-container"},
+					},
+				},
+			},
+			namespaceLabels: map[string]string{"env": "test"},
+			conf: config.Config{
+				Sidecars: map[string]config.SidecarConfig{
+					"test-container": {NamespaceSelector: &v1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+				},
+			},
+		},
 	}
 
 	for _, testcase := range testcases {
 		t.Run(testcase.name, func(t *testing.T) {
-			m := NewPodOwnerModifier(testcase.conf)
-			res := m.matchDetails(testcase.pod)
+			m := NewPodOwnerModifier(config.NewStore(testcase.conf))
+			res := m.matchDetails(testcase.pod, testcase.namespaceLabels)
 			assert.Equal(t, testcase.expected, res)
 		})
 	}
@@ -406,7 +504,7 @@ func TestFilterTerminated(t *testing.T) {
 
 	for _, testcase := range testcases {
 		t.Run(testcase.name, func(t *testing.T) {
-			m := NewPodOwnerModifier(config.Config{})
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
 			res := m.filterTerminated(testcase.details)
 			assert.Equal(t, testcase.expected, res)
 		})
@@ -460,7 +558,7 @@ func TestGroupByOwner(t *testing.T) {
 
 	for _, testcase := range testcases {
 		t.Run(testcase.name, func(t *testing.T) {
-			m := NewPodOwnerModifier(config.Config{})
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
 			res := m.groupByOwner(testcase.details)
 			assert.Equal(t, testcase.expected, res)
 		})
@@ -479,6 +577,7 @@ func TestNewAnnotations(t *testing.T) {
 		newDasDetails           map[string]dasDetail
 		newOwnerAnnotations     map[string]string
 		newPodAnnotations       map[string]string
+		newDecisions            []decision
 		err                     error
 	}{
 		{
@@ -646,6 +745,7 @@ func TestNewAnnotations(t *testing.T) {
 			newDasDetails: map[string]dasDetail{
 				"test-container": dasDetail{
 					Name: "test-step-1",
+					Keys: []string{"test-cpu-limit-key", "test-cpu-request-key", "test-mem-limit-key", "test-mem-request-key"},
 				},
 			},
 			currentOwnerAnnotations: make(map[string]string),
@@ -656,6 +756,152 @@ func TestNewAnnotations(t *testing.T) {
 				"test-mem-request-key": "1Gi",
 				"test-mem-limit-key":   "1Gi",
 			},
+			newDecisions: []decision{
+				{
+					container:    "test-container",
+					previous:     dasDetail{Name: "test-step", RestartCount: 6},
+					restartCount: 7,
+					from:         config.ResourceStep{Name: "test-step", RestartLimit: 5},
+					to: config.ResourceStep{
+						Name:         "test-step-1",
+						RestartLimit: 5,
+						CPURequest:   "1",
+						CPULimit:     "1",
+						MemRequest:   "1Gi",
+						MemLimit:     "1Gi",
+					},
+				},
+			},
+		},
+		{
+			name: "write next step at owner level when annotation keys are already defined on the owner",
+			details: []containerDetail{
+				{
+					sidecarConfig: config.SidecarConfig{
+						Steps: []config.ResourceStep{
+							{
+								Name:         "test-step",
+								RestartLimit: 5,
+							},
+							{
+								Name:         "test-step-1",
+								RestartLimit: 5,
+								CPURequest:   "1",
+								CPULimit:     "1",
+								MemRequest:   "1Gi",
+								MemLimit:     "1Gi",
+							},
+						},
+						CPUAnnotationKey:      "test-cpu-request-key",
+						CPULimitAnnotationKey: "test-cpu-limit-key",
+						MemAnnotationKey:      "test-mem-request-key",
+						MemLimitAnnotationKey: "test-mem-limit-key",
+						AnnotationLevel:       config.AutoLevel,
+					},
+					containerStatus: corev1.ContainerStatus{
+						Name: "test-container",
+					},
+				},
+			},
+			currentDasDetails: map[string]dasDetail{
+				"test-container": dasDetail{
+					Name:         "test-step",
+					RestartCount: 6,
+				},
+			},
+			newDasDetails: map[string]dasDetail{
+				"test-container": dasDetail{
+					Name:      "test-step-1",
+					Keys:      []string{"test-mem-limit-key", "test-mem-request-key"},
+					OwnerKeys: []string{"test-cpu-limit-key", "test-cpu-request-key"},
+				},
+			},
+			currentOwnerAnnotations: map[string]string{
+				"test-cpu-request-key": "500m",
+				"test-cpu-limit-key":   "500m",
+			},
+			newOwnerAnnotations: map[string]string{
+				"test-cpu-request-key": "1",
+				"test-cpu-limit-key":   "1",
+			},
+			newPodAnnotations: map[string]string{
+				"test-mem-request-key": "1Gi",
+				"test-mem-limit-key":   "1Gi",
+			},
+			newDecisions: []decision{
+				{
+					container:    "test-container",
+					previous:     dasDetail{Name: "test-step", RestartCount: 6},
+					restartCount: 7,
+					from:         config.ResourceStep{Name: "test-step", RestartLimit: 5},
+					to: config.ResourceStep{
+						Name:         "test-step-1",
+						RestartLimit: 5,
+						CPURequest:   "1",
+						CPULimit:     "1",
+						MemRequest:   "1Gi",
+						MemLimit:     "1Gi",
+					},
+				},
+			},
+		},
+		{
+			name: "record keys at owner level when the key is on both the owner and the pod template",
+			details: []containerDetail{
+				{
+					sidecarConfig: config.SidecarConfig{
+						Steps: []config.ResourceStep{
+							{
+								Name:         "test-step",
+								RestartLimit: 5,
+							},
+							{
+								Name:         "test-step-1",
+								RestartLimit: 5,
+								CPURequest:   "1",
+							},
+						},
+						CPUAnnotationKey: "test-cpu-request-key",
+						AnnotationLevel:  config.OwnerLevel,
+					},
+					containerStatus: corev1.ContainerStatus{
+						Name: "test-container",
+					},
+				},
+			},
+			currentDasDetails: map[string]dasDetail{
+				"test-container": dasDetail{
+					Name:         "test-step",
+					RestartCount: 6,
+				},
+			},
+			newDasDetails: map[string]dasDetail{
+				"test-container": dasDetail{
+					Name:      "test-step-1",
+					OwnerKeys: []string{"test-cpu-request-key"},
+				},
+			},
+			currentOwnerAnnotations: map[string]string{
+				"test-cpu-request-key": "500m",
+			},
+			currentPodAnnotations: map[string]string{
+				"test-cpu-request-key": "250m",
+			},
+			newOwnerAnnotations: map[string]string{
+				"test-cpu-request-key": "1",
+			},
+			newPodAnnotations: map[string]string{
+				"test-cpu-request-key": "250m",
+			},
+			newDecisions: []decision{
+				{
+					container:    "test-container",
+					previous:     dasDetail{Name: "test-step", RestartCount: 6},
+					restartCount: 7,
+					from:         config.ResourceStep{Name: "test-step", RestartLimit: 5},
+					to:           config.ResourceStep{Name: "test-step-1", RestartLimit: 5, CPURequest: "1"},
+				},
+			},
 		},
 	}
 
@@ -669,13 +915,563 @@ func TestNewAnnotations(t *testing.T) {
 				newDetailsStr, _ := json.Marshal(testcase.newDasDetails)
 				testcase.newOwnerAnnotations["das/details"] = string(newDetailsStr)
 			}
+			// decisions carry the sidecar config they were made with
+			for i := range testcase.newDecisions {
+				testcase.newDecisions[i].sidecarConfig = testcase.details[0].sidecarConfig
+			}
 
-			m := NewPodOwnerModifier(config.Config{})
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
 			res, err := m.newAnnotations(testcase.details, testcase.currentOwnerAnnotations, testcase.currentPodAnnotations)
 			assert.Equal(t, testcase.newOwnerAnnotations, res.ownerAnnotations)
 			assert.Equal(t, testcase.newPodAnnotations, res.podAnnotations)
+			assert.Equal(t, testcase.newDecisions, res.decisions)
 			assert.Equal(t, testcase.err, err)
 		})
 	}
 
 }
+
+func TestAnnotationTarget(t *testing.T) {
+	testcases := []struct {
+		name             string
+		level            config.AnnotationLevel
+		ownerAnnotations map[string]string
+		podAnnotations   map[string]string
+		expectOwner      bool
+	}{
+		{
+			name:  "auto defaults to pod template when key is not defined anywhere",
+			level: config.AutoLevel,
+		},
+		{
+			name:             "auto picks owner when key is only on the owner",
+			level:            config.AutoLevel,
+			ownerAnnotations: map[string]string{"test-key": "1"},
+			expectOwner:      true,
+		},
+		{
+			name:             "auto picks pod template when key is on both",
+			level:            config.AutoLevel,
+			ownerAnnotations: map[string]string{"test-key": "1"},
+			podAnnotations:   map[string]string{"test-key": "1"},
+		},
+		{
+			name:           "owner level always picks owner",
+			level:          config.OwnerLevel,
+			podAnnotations: map[string]string{"test-key": "1"},
+			expectOwner:    true,
+		},
+		{
+			name:             "pod level always picks pod template",
+			level:            config.PodLevel,
+			ownerAnnotations: map[string]string{"test-key": "1"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			ownerAnnotations := map[string]string{"marker": "owner"}
+			for k, v := range testcase.ownerAnnotations {
+				ownerAnnotations[k] = v
+			}
+			podAnnotations := map[string]string{"marker": "pod"}
+			for k, v := range testcase.podAnnotations {
+				podAnnotations[k] = v
+			}
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+			res := m.annotationTarget(testcase.level, "test-key", ownerAnnotations, podAnnotations)
+			if testcase.expectOwner {
+				assert.Equal(t, "owner", res["marker"])
+			} else {
+				assert.Equal(t, "pod", res["marker"])
+			}
+		})
+	}
+}
+
+func TestOwnedAnnotations(t *testing.T) {
+	testcases := []struct {
+		name             string
+		ownerAnnotations map[string]string
+		podAnnotations   map[string]string
+		expectedOwner    map[string]string
+		expectedPod      map[string]string
+	}{
+		{
+			name:          "return empty maps when nothing das owns is present",
+			expectedOwner: make(map[string]string),
+			expectedPod:   make(map[string]string),
+		},
+		{
+			name: "return only das details and the keys das wrote",
+			ownerAnnotations: map[string]string{
+				"das/details":    `{"test-container":{"name":"test-step-1","restart_count":0,"keys":["test-cpu-request-key","test-mem-request-key"]}}`,
+				"helm/something": "value",
+			},
+			podAnnotations: map[string]string{
+				"test-cpu-request-key": "1",
+				"test-mem-request-key": "1Gi",
+				"other-key":            "value",
+			},
+			expectedOwner: map[string]string{
+				"das/details": `{"test-container":{"name":"test-step-1","restart_count":0,"keys":["test-cpu-request-key","test-mem-request-key"]}}`,
+			},
+			expectedPod: map[string]string{
+				"test-cpu-request-key": "1",
+				"test-mem-request-key": "1Gi",
+			},
+		},
+		{
+			name: "keep keys of containers not part of the current event",
+			ownerAnnotations: map[string]string{
+				"das/details": `{"test-container":{"name":"test-step-1","restart_count":0,"keys":["test-cpu-request-key"]},"test-container-1":{"name":"test-step-1","restart_count":0,"keys":["test-cpu-request-key-1"]}}`,
+			},
+			podAnnotations: map[string]string{
+				"test-cpu-request-key":   "1",
+				"test-cpu-request-key-1": "2",
+			},
+			expectedOwner: map[string]string{
+				"das/details": `{"test-container":{"name":"test-step-1","restart_count":0,"keys":["test-cpu-request-key"]},"test-container-1":{"name":"test-step-1","restart_count":0,"keys":["test-cpu-request-key-1"]}}`,
+			},
+			expectedPod: map[string]string{
+				"test-cpu-request-key":   "1",
+				"test-cpu-request-key-1": "2",
+			},
+		},
+		{
+			name: "leave configured keys das never wrote to whoever set them",
+			ownerAnnotations: map[string]string{
+				"das/details":          `{"test-container":{"name":"test-step-1","restart_count":1}}`,
+				"test-cpu-request-key": "1",
+			},
+			expectedOwner: map[string]string{
+				"das/details": `{"test-container":{"name":"test-step-1","restart_count":1}}`,
+			},
+			expectedPod: make(map[string]string),
+		},
+		{
+			name: "claim a key on both levels only at the level das wrote it",
+			ownerAnnotations: map[string]string{
+				"das/details":          `{"test-container":{"name":"test-step-1","restart_count":0,"owner_keys":["test-cpu-request-key"]}}`,
+				"test-cpu-request-key": "1",
+			},
+			podAnnotations: map[string]string{
+				"test-cpu-request-key": "250m",
+			},
+			expectedOwner: map[string]string{
+				"das/details":          `{"test-container":{"name":"test-step-1","restart_count":0,"owner_keys":["test-cpu-request-key"]}}`,
+				"test-cpu-request-key": "1",
+			},
+			expectedPod: make(map[string]string),
+		},
+		{
+			name: "claim an owner level key on the owner",
+			ownerAnnotations: map[string]string{
+				"das/details":          `{"test-container":{"name":"test-step-1","restart_count":0,"keys":["test-cpu-request-key"]}}`,
+				"test-cpu-request-key": "1",
+			},
+			expectedOwner: map[string]string{
+				"das/details":          `{"test-container":{"name":"test-step-1","restart_count":0,"keys":["test-cpu-request-key"]}}`,
+				"test-cpu-request-key": "1",
+			},
+			expectedPod: make(map[string]string),
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+			owner, pod := m.ownedAnnotations(testcase.ownerAnnotations, testcase.podAnnotations)
+			assert.Equal(t, testcase.expectedOwner, owner)
+			assert.Equal(t, testcase.expectedPod, pod)
+		})
+	}
+}
+
+// TestOwnedTemplatedKeys checks the step annotations written for a sidecar matched by pattern reach the apply.
+func TestOwnedTemplatedKeys(t *testing.T) {
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{
+		"log-shippers": {
+			Container:        "log-shipper-*",
+			Owner:            config.Deployment,
+			CPUAnnotationKey: "{{.Container}}/cpu",
+			Steps: []config.ResourceStep{
+				{Name: "test-step-1", RestartLimit: 1, CPURequest: "100m"},
+				{Name: "test-step-2", RestartLimit: 1, CPURequest: "200m"},
+			},
+		},
+	}}
+	m := NewPodOwnerModifier(config.NewStore(conf))
+	_, sidecarConfig, ok := conf.SidecarFor("log-shipper-a", config.Workload{})
+	assert.True(t, ok)
+	details := []containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "log-shipper-a"}}}
+	ownerAnnotations := map[string]string{dasDetailsKey: `{"log-shipper-a":{"name":"test-step-1","restart_count":0}}`}
+
+	res, err := m.newAnnotations(details, ownerAnnotations, nil)
+	assert.NoError(t, err)
+	_, pod := m.ownedAnnotations(res.ownerAnnotations, res.podAnnotations)
+	assert.Equal(t, map[string]string{"log-shipper-a/cpu": "200m"}, pod)
+}
+
+func TestHoldAndReplaceStep(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Steps: []config.ResourceStep{
+			{Name: "test-step", RestartLimit: 5, CPURequest: "500m"},
+			{Name: "test-step-1", RestartLimit: 5, CPURequest: "1"},
+			{Name: "test-step-2", RestartLimit: 5, CPURequest: "2"},
+		},
+		CPUAnnotationKey: "test-cpu-request-key",
+	}
+	details := []containerDetail{
+		{
+			sidecarConfig:   sidecarConfig,
+			containerStatus: corev1.ContainerStatus{Name: "test-container"},
+		},
+	}
+	currentDetails, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-1", RestartCount: 5}})
+
+	t.Run("hold restores the previous annotations and keeps the restart count", func(t *testing.T) {
+		m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+		res, err := m.newAnnotations(details, map[string]string{"das/details": string(currentDetails)}, map[string]string{"test-cpu-request-key": "1"})
+		assert.Nil(t, err)
+		assert.Equal(t, "2", res.podAnnotations["test-cpu-request-key"])
+
+		err = m.holdStep(&res, 0, "test reason")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"test-cpu-request-key": "1"}, res.podAnnotations)
+		heldDetails, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-1", RestartCount: 6}})
+		assert.Equal(t, string(heldDetails), res.ownerAnnotations["das/details"])
+		assert.Empty(t, res.steps)
+		assert.True(t, res.decisions[0].held)
+		assert.Equal(t, "test reason", res.decisions[0].holdReason)
+	})
+
+	t.Run("hold removes annotations that were not there before", func(t *testing.T) {
+		m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+		res, err := m.newAnnotations(details, map[string]string{"das/details": string(currentDetails)}, nil)
+		assert.Nil(t, err)
+
+		err = m.holdStep(&res, 0, "test reason")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{}, res.podAnnotations)
+	})
+
+	t.Run("replace swaps the step and records a note", func(t *testing.T) {
+		m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+		lowerDetails, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step", RestartCount: 5}})
+		res, err := m.newAnnotations(details, map[string]string{"das/details": string(lowerDetails)}, nil)
+		assert.Nil(t, err)
+
+		err = m.replaceStep(&res, 0, config.ResourceStep{Name: "test-step-1", CPURequest: "750m"}, "test note")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"test-cpu-request-key": "750m"}, res.podAnnotations)
+		newDetails, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-1", Adjustment: "test note", Keys: []string{"test-cpu-request-key"}}})
+		assert.Equal(t, string(newDetails), res.ownerAnnotations["das/details"])
+		assert.Equal(t, "750m", res.steps["test-container"].CPURequest)
+		assert.Equal(t, []string{"test note"}, res.decisions[0].notes)
+	})
+}
+
+func TestSweepFloors(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", RestartLimit: 5, CPURequest: "500m"},
+			{Name: "test-step-2", RestartLimit: 5, CPURequest: "1"},
+			{Name: "test-step-3", RestartLimit: 5, CPURequest: "2"},
+			{Name: "test-step-4", RestartLimit: 5, CPURequest: "4"},
+		},
+		CPUAnnotationKey: "test-cpu-request-key",
+		Floors: []config.FloorRule{
+			{Step: "test-step-3", Days: "Mon-Fri", Start: "08:00", End: "11:00"},
+		},
+	}
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}}
+	// 2024-09-16 is a monday
+	inWindow := time.Date(2024, 9, 16, 9, 0, 0, 0, time.UTC)
+	afterWindow := time.Date(2024, 9, 16, 12, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		name              string
+		now               time.Time
+		currentDasDetails map[string]dasDetail
+		newDasDetails     map[string]dasDetail
+		newPodAnnotations map[string]string
+	}{
+		{
+			name:              "raise to the floor inside the window",
+			now:               inWindow,
+			currentDasDetails: map[string]dasDetail{"test-container": {Name: "test-step-1", RestartCount: 2}},
+			newDasDetails:     map[string]dasDetail{"test-container": {Name: "test-step-1", RestartCount: 2, Floor: "test-step-3", Keys: []string{"test-cpu-request-key"}}},
+			newPodAnnotations: map[string]string{"test-cpu-request-key": "2"},
+		},
+		{
+			name:              "go back to the learnt step after the window",
+			now:               afterWindow,
+			currentDasDetails: map[string]dasDetail{"test-container": {Name: "test-step-1", RestartCount: 2, Floor: "test-step-3"}},
+			newDasDetails:     map[string]dasDetail{"test-container": {Name: "test-step-1", RestartCount: 2, Keys: []string{"test-cpu-request-key"}}},
+			newPodAnnotations: map[string]string{"test-cpu-request-key": "500m"},
+		},
+		{
+			name:              "leave learnt steps above the floor alone",
+			now:               inWindow,
+			currentDasDetails: map[string]dasDetail{"test-container": {Name: "test-step-4"}},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			currentDetailsStr, _ := json.Marshal(testcase.currentDasDetails)
+			ownerAnnotations := map[string]string{"das/details": string(currentDetailsStr)}
+			m := NewPodOwnerModifier(config.NewStore(conf))
+			m.now = func() time.Time { return testcase.now }
+			res, err := m.sweepSteps(config.Workload{}, ownerAnnotations, nil, nil)
+			assert.Nil(t, err)
+			if testcase.newDasDetails == nil {
+				assert.Empty(t, res.decisions)
+				return
+			}
+			newDetailsStr, _ := json.Marshal(testcase.newDasDetails)
+			assert.Equal(t, string(newDetailsStr), res.ownerAnnotations["das/details"])
+			assert.Equal(t, testcase.newPodAnnotations, res.podAnnotations)
+			// the annotations passed in come from the cache and must not change
+			assert.Equal(t, string(currentDetailsStr), ownerAnnotations["das/details"])
+		})
+	}
+
+	t.Run("escalate from the floor on restarts", func(t *testing.T) {
+		currentDetailsStr, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-1", RestartCount: 4, Floor: "test-step-3"}})
+		m := NewPodOwnerModifier(config.NewStore(conf))
+		res, err := m.newAnnotations([]containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}}}, map[string]string{"das/details": string(currentDetailsStr)}, nil)
+		assert.Nil(t, err)
+		newDetailsStr, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-4", Keys: []string{"test-cpu-request-key"}}})
+		assert.Equal(t, string(newDetailsStr), res.ownerAnnotations["das/details"])
+		assert.Equal(t, "test-step-3", res.decisions[0].from.Name)
+	})
+
+	t.Run("use the ladder the owner picked", func(t *testing.T) {
+		laddered := sidecarConfig
+		laddered.Guardrails = config.Guardrails{AllowOverrides: true}
+		ladderConf := config.Config{
+			Sidecars: map[string]config.SidecarConfig{"test-container": laddered},
+			Ladders: map[string][]config.ResourceStep{"big": {
+				{Name: "test-step-1", RestartLimit: 5, CPURequest: "1"},
+				{Name: "test-step-3", RestartLimit: 5, CPURequest: "3"},
+			}},
+		}
+		currentDetailsStr, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-1"}})
+		m := NewPodOwnerModifier(config.NewStore(ladderConf))
+		m.now = func() time.Time { return inWindow }
+		res, err := m.sweepSteps(config.Workload{}, map[string]string{"das/details": string(currentDetailsStr), ladderAnnotationKey: "big"}, nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"test-cpu-request-key": "3"}, res.podAnnotations)
+	})
+
+	t.Run("map the floor onto a node pool ladder", func(t *testing.T) {
+		pooled := sidecarConfig
+		pooled.NodePoolLadders = []config.NodePoolLadder{{Name: "arm", Steps: []config.ResourceStep{
+			{Name: "arm-1", RestartLimit: 5, CPURequest: "1"},
+			{Name: "arm-2", RestartLimit: 5, CPURequest: "3"},
+		}}}
+		pooledConf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": pooled}}
+		currentDetailsStr, _ := json.Marshal(map[string]dasDetail{"test-container@arm": {Name: "arm-1"}})
+		m := NewPodOwnerModifier(config.NewStore(pooledConf))
+		m.now = func() time.Time { return inWindow }
+		res, err := m.sweepSteps(config.Workload{}, map[string]string{"das/details": string(currentDetailsStr)}, nil, nil)
+		assert.Nil(t, err)
+		// test-step-3 asks for 2 cpus, which arm-2 is the first arm step to cover
+		assert.Equal(t, map[string]string{"test-cpu-request-key": "3"}, res.podAnnotations)
+		assert.Equal(t, "arm-2", res.decisions[0].to.Name)
+	})
+}
+
+func TestImageReset(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		CPUAnnotationKey: "test-cpu-request-key",
+		ImageResetStep:   "test-step-2",
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", CPURequest: "100m", RestartLimit: 3},
+			{Name: "test-step-2", CPURequest: "200m", RestartLimit: 3},
+			{Name: "test-step-3", CPURequest: "300m", RestartLimit: 3},
+		},
+	}
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}}
+	testcases := []struct {
+		name              string
+		image             string
+		currentDasDetails map[string]dasDetail
+		newDasDetails     map[string]dasDetail
+		newPodAnnotations map[string]string
+		expectedDecisions int
+	}{
+		{
+			name:              "drop back to the reset step on a new image",
+			image:             "envoy:1.31",
+			currentDasDetails: map[string]dasDetail{"test-container": {Name: "test-step-3", RestartCount: 1, Image: "envoy:1.30"}},
+			newDasDetails:     map[string]dasDetail{"test-container": {Name: "test-step-2", Image: "envoy:1.31", Keys: []string{"test-cpu-request-key"}}},
+			newPodAnnotations: map[string]string{"test-cpu-request-key": "200m"},
+			expectedDecisions: 1,
+		},
+		{
+			name:              "reset counts when already on the reset step",
+			image:             "envoy:1.31",
+			currentDasDetails: map[string]dasDetail{"test-container": {Name: "test-step-2", RestartCount: 2, Image: "envoy:1.30"}},
+			newDasDetails:     map[string]dasDetail{"test-container": {Name: "test-step-2", Image: "envoy:1.31"}},
+			newPodAnnotations: map[string]string{},
+		},
+		{
+			name:              "leave the step on the same image",
+			image:             "envoy:1.30",
+			currentDasDetails: map[string]dasDetail{"test-container": {Name: "test-step-3", RestartCount: 1, Image: "envoy:1.30"}},
+			newDasDetails:     map[string]dasDetail{"test-container": {Name: "test-step-3", RestartCount: 1, Image: "envoy:1.30"}},
+			newPodAnnotations: map[string]string{},
+		},
+		{
+			name:              "record the image when none is recorded",
+			image:             "envoy:1.30",
+			currentDasDetails: map[string]dasDetail{"test-container": {Name: "test-step-3", RestartCount: 1}},
+			newDasDetails:     map[string]dasDetail{"test-container": {Name: "test-step-3", RestartCount: 1, Image: "envoy:1.30"}},
+			newPodAnnotations: map[string]string{},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			currentDetailsStr, _ := json.Marshal(testcase.currentDasDetails)
+			m := NewPodOwnerModifier(config.NewStore(conf))
+			res, err := m.sweepSteps(config.Workload{}, map[string]string{"das/details": string(currentDetailsStr)}, nil, map[string]string{"test-container": testcase.image})
+			assert.Nil(t, err)
+			newDetailsStr, _ := json.Marshal(testcase.newDasDetails)
+			assert.Equal(t, string(newDetailsStr), res.ownerAnnotations["das/details"])
+			assert.Equal(t, testcase.newPodAnnotations, res.podAnnotations)
+			assert.Len(t, res.decisions, testcase.expectedDecisions)
+		})
+	}
+
+	t.Run("reset on the sweep after stepping up on a new image", func(t *testing.T) {
+		currentDetailsStr, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-2", RestartCount: 2, Image: "envoy:1.30"}})
+		m := NewPodOwnerModifier(config.NewStore(conf))
+		details := []containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}, image: "envoy:1.31"}}
+		res, err := m.newAnnotations(details, map[string]string{"das/details": string(currentDetailsStr)}, nil)
+		assert.Nil(t, err)
+		// the step up keeps the recorded image, leaving the image change to the sweep
+		newDetailsStr, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-3", Image: "envoy:1.30", Keys: []string{"test-cpu-request-key"}}})
+		assert.Equal(t, string(newDetailsStr), res.ownerAnnotations["das/details"])
+
+		res, err = m.sweepSteps(config.Workload{}, res.ownerAnnotations, res.podAnnotations, map[string]string{"test-container": "envoy:1.31"})
+		assert.Nil(t, err)
+		sweptDetailsStr, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "test-step-2", Image: "envoy:1.31", Keys: []string{"test-cpu-request-key"}}})
+		assert.Equal(t, string(sweptDetailsStr), res.ownerAnnotations["das/details"])
+		assert.Equal(t, "200m", res.podAnnotations["test-cpu-request-key"])
+	})
+}
+
+// TestLearntStepOffLadder runs a learnt step that an override's start step trimmed off the ladder through newAnnotations.
+func TestLearntStepOffLadder(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		CPUAnnotationKey: "test-cpu-request-key",
+		Guardrails:       config.Guardrails{AllowOverrides: true},
+		Steps: []config.ResourceStep{
+			{Name: "small", RestartLimit: 3, CPURequest: "100m"},
+			{Name: "medium", RestartLimit: 3, CPURequest: "200m"},
+			{Name: "large", RestartLimit: 3, CPURequest: "400m"},
+			{Name: "xl", RestartLimit: 3, CPURequest: "800m"},
+		},
+	}
+	overridden, err := sidecarConfig.WithOverride(config.Override{StartStep: "medium"})
+	assert.NoError(t, err)
+
+	testcases := []struct {
+		name              string
+		running           string
+		expectedDetail    dasDetail
+		expectedDecisions int
+		expectedCPU       string
+	}{
+		{
+			name:              "raise a learnt step below the start step to the start step",
+			running:           "100m",
+			expectedDetail:    dasDetail{Name: "medium", RestartCount: 1, Keys: []string{"test-cpu-request-key"}},
+			expectedDecisions: 1,
+			expectedCPU:       "200m",
+		},
+		{
+			name:           "keep a container already running on the ladder",
+			running:        "400m",
+			expectedDetail: dasDetail{Name: "large", RestartCount: 1, Keys: []string{"test-cpu-request-key"}},
+			expectedCPU:    "400m",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+			currentDetails, _ := json.Marshal(map[string]dasDetail{"test-container": {Name: "small", RestartCount: 2, Keys: []string{"test-cpu-request-key"}}})
+			details := []containerDetail{{sidecarConfig: overridden, containerStatus: corev1.ContainerStatus{Name: "test-container"}}}
+			res, err := m.newAnnotations(details, map[string]string{dasDetailsKey: string(currentDetails)}, map[string]string{"test-cpu-request-key": testcase.running})
+			assert.NoError(t, err)
+			assert.Equal(t, testcase.expectedDetail, res.dasDetails["test-container"])
+			assert.Equal(t, testcase.expectedCPU, res.podAnnotations["test-cpu-request-key"])
+			assert.Len(t, res.decisions, testcase.expectedDecisions)
+			if testcase.expectedDecisions > 0 {
+				assert.Equal(t, "small", res.decisions[0].from.Name)
+				assert.Equal(t, "100m", res.decisions[0].from.CPURequest)
+				assert.Equal(t, "medium", res.decisions[0].to.Name)
+			}
+		})
+	}
+}
+
+func TestInferStep(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		CPUAnnotationKey: "test-cpu-request-key",
+		MemAnnotationKey: "test-mem-request-key",
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", CPURequest: "100m", MemRequest: "128Mi"},
+			{Name: "test-step-2", CPURequest: "200m", MemRequest: "256Mi"},
+			{Name: "test-step-3", CPURequest: "400m", MemRequest: "512Mi"},
+		},
+	}
+	testcases := []struct {
+		name             string
+		resources        corev1.ResourceRequirements
+		ownerAnnotations map[string]string
+		podAnnotations   map[string]string
+		expected         string
+	}{
+		{
+			name:     "first step without annotations or resources",
+			expected: "test-step-1",
+		},
+		{
+			name:           "largest step at or below the pod annotations",
+			podAnnotations: map[string]string{"test-cpu-request-key": "300m", "test-mem-request-key": "512Mi"},
+			expected:       "test-step-2",
+		},
+		{
+			name:             "pod annotations take precedence over owner annotations",
+			ownerAnnotations: map[string]string{"test-cpu-request-key": "100m"},
+			podAnnotations:   map[string]string{"test-cpu-request-key": "400m"},
+			expected:         "test-step-3",
+		},
+		{
+			name: "container resources without annotations",
+			resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("0.2"), corev1.ResourceMemory: resource.MustParse("256Mi")},
+			},
+			expected: "test-step-2",
+		},
+		{
+			name:           "first step when running below every step",
+			podAnnotations: map[string]string{"test-cpu-request-key": "50m"},
+			expected:       "test-step-1",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+			d := containerDetail{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}, resources: testcase.resources}
+			assert.Equal(t, testcase.expected, m.inferStep(d, testcase.ownerAnnotations, testcase.podAnnotations).Name)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// namespaceLabels returns the labels of a namespace to match sidecar namespace selectors against.
+// a namespace that cannot be read is treated as having no labels.
+func (r *PodReconciler) namespaceLabels(ctx context.Context, name string) map[string]string {
+	var ns corev1.Namespace
+	err := r.Get(ctx, types.NamespacedName{Name: name}, &ns)
+	if err != nil {
+		slog.Warn("error getting namespace for sidecar selectors", "namespace", name, "err", err.Error())
+		return nil
+	}
+	return ns.Labels
+}
+
+// allNamespaceLabels returns the labels of every namespace by name, for work across all owners.
+func (r *PodReconciler) allNamespaceLabels(ctx context.Context) map[string]map[string]string {
+	res := make(map[string]map[string]string)
+	var namespaces corev1.NamespaceList
+	err := r.List(ctx, &namespaces)
+	if err != nil {
+		slog.Warn("error listing namespaces for sidecar selectors", "err", err.Error())
+		return res
+	}
+	for _, ns := range namespaces.Items {
+		res[ns.Name] = ns.Labels
+	}
+	return res
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// checkNodeCapacity holds or caps step changes that would make the pod's total requests larger than
+// the allocatable capacity of every node the owner's pods can be scheduled on.
+// nodes come from the manager's cache, so das keeps track of node capacity as nodes come and go.
+func (r *PodReconciler) checkNodeCapacity(ctx context.Context, target ownerTarget, res *newAnnotations) error {
+	if target.pod == nil || !needsCheck(res.decisions, func(sidecarConfig config.SidecarConfig) config.ConstraintPolicy {
+		return sidecarConfig.NodeCapacityPolicy
+	}) {
+		return nil
+	}
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes); err != nil {
+		return fmt.Errorf("error listing nodes: %w", err)
+	}
+	eligible := eligibleNodes(nodes.Items, target.podTemplate.Spec)
+	if len(eligible) == 0 {
+		return nil
+	}
+	largestCPU := largestAllocatable(eligible, corev1.ResourceCPU)
+	largestMemory := largestAllocatable(eligible, corev1.ResourceMemory)
+
+	for i := range res.decisions {
+		d := res.decisions[i]
+		fits := func(step config.ResourceStep) bool {
+			return fitsAnyNode(eligible, podRequests(target.pod, d.container, step))
+		}
+		reason := fmt.Sprintf("no eligible node has allocatable capacity for the pod with step %s (largest allocatable cpu %s, memory %s)",
+			d.to.Name, largestCPU.String(), largestMemory.String())
+		if err := r.constrain(target, res, i, d.sidecarConfig.NodeCapacityPolicy, reason, fits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podRequests is the total requests of the pod with the container's requests replaced by the step's.
+// init containers run one at a time before the app containers, so as in the scheduler, the pod needs the larger of
+// the largest init container and the app containers together, plus the pod overhead.
+// a sidecar injected after the template was read may not have requests on the pod yet, so the step is added either way.
+func podRequests(pod *corev1.Pod, containerName string, step config.ResourceStep) corev1.ResourceList {
+	res := make(corev1.ResourceList)
+	add := func(list corev1.ResourceList) {
+		for name, q := range list {
+			total := res[name]
+			total.Add(q)
+			res[name] = total
+		}
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == containerName {
+			continue
+		}
+		add(container.Resources.Requests)
+	}
+	add(stepResources(step).Requests)
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == containerName {
+			continue
+		}
+		for name, q := range container.Resources.Requests {
+			if total, ok := res[name]; !ok || q.Cmp(total) > 0 {
+				res[name] = q.DeepCopy()
+			}
+		}
+	}
+	add(pod.Spec.Overhead)
+	return res
+}
+
+func fitsAnyNode(nodes []corev1.Node, requests corev1.ResourceList) bool {
+	for _, node := range nodes {
+		fits := true
+		for name, q := range requests {
+			allocatable, ok := node.Status.Allocatable[name]
+			if !ok {
+				continue
+			}
+			if q.Cmp(allocatable) > 0 {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return true
+		}
+	}
+	return false
+}
+
+// eligibleNodes filters nodes by the node selector and required node affinity of the pod spec.
+// taints and preferred affinity are not looked at.
+func eligibleNodes(nodes []corev1.Node, spec corev1.PodSpec) []corev1.Node {
+	var res []corev1.Node
+	nodeSelector := labels.SelectorFromSet(spec.NodeSelector)
+	var terms []corev1.NodeSelectorTerm
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil && spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms = spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	}
+	for _, node := range nodes {
+		nodeLabels := labels.Set(node.Labels)
+		if !nodeSelector.Matches(nodeLabels) {
+			continue
+		}
+		if len(terms) > 0 && !matchesAnyTerm(terms, nodeLabels) {
+			continue
+		}
+		res = append(res, node)
+	}
+	return res
+}
+
+func matchesAnyTerm(terms []corev1.NodeSelectorTerm, nodeLabels labels.Set) bool {
+	for _, term := range terms {
+		selector := labels.NewSelector()
+		valid := true
+		for _, expression := range term.MatchExpressions {
+			requirement, err := nodeSelectorRequirement(expression)
+			if err != nil {
+				valid = false
+				break
+			}
+			selector = selector.Add(*requirement)
+		}
+		if valid && selector.Matches(nodeLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeSelectorRequirement(expression corev1.NodeSelectorRequirement) (*labels.Requirement, error) {
+	var op selection.Operator
+	switch expression.Operator {
+	case corev1.NodeSelectorOpIn:
+		op = selection.In
+	case corev1.NodeSelectorOpNotIn:
+		op = selection.NotIn
+	case corev1.NodeSelectorOpExists:
+		op = selection.Exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		op = selection.DoesNotExist
+	case corev1.NodeSelectorOpGt:
+		op = selection.GreaterThan
+	case corev1.NodeSelectorOpLt:
+		op = selection.LessThan
+	default:
+		return nil, fmt.Errorf("unknown node selector operator %s", expression.Operator)
+	}
+	return labels.NewRequirement(expression.Key, op, expression.Values)
+}
+
+// largestAllocatable is the most of a resource any of the nodes can give.
+func largestAllocatable(nodes []corev1.Node, name corev1.ResourceName) resource.Quantity {
+	var res resource.Quantity
+	for _, node := range nodes {
+		if q, ok := node.Status.Allocatable[name]; ok && q.Cmp(res) > 0 {
+			res = q
+		}
+	}
+	return res
+}
@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestEligibleNodes(t *testing.T) {
+	nodes := []corev1.Node{
+		{ObjectMeta: v1.ObjectMeta{Name: "arm-node", Labels: map[string]string{"kubernetes.io/arch": "arm64", "pool": "spot"}}},
+		{ObjectMeta: v1.ObjectMeta{Name: "x86-node", Labels: map[string]string{"kubernetes.io/arch": "amd64", "pool": "on-demand"}}},
+	}
+	testcases := []struct {
+		name     string
+		spec     corev1.PodSpec
+		expected []string
+	}{
+		{
+			name:     "all nodes without selector or affinity",
+			expected: []string{"arm-node", "x86-node"},
+		},
+		{
+			name:     "filter by node selector",
+			spec:     corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/arch": "arm64"}},
+			expected: []string{"arm-node"},
+		},
+		{
+			name: "filter by required node affinity",
+			spec: corev1.PodSpec{
+				Affinity: &corev1.Affinity{
+					NodeAffinity: &corev1.NodeAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{
+								{
+									MatchExpressions: []corev1.NodeSelectorRequirement{
+										{Key: "pool", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"spot"}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: []string{"x86-node"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			var res []string
+			for _, node := range eligibleNodes(nodes, testcase.spec) {
+				res = append(res, node.Name)
+			}
+			assert.Equal(t, testcase.expected, res)
+		})
+	}
+}
+
+func TestFitsAnyNode(t *testing.T) {
+	nodes := []corev1.Node{
+		{Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4"), corev1.ResourceMemory: resource.MustParse("4Gi")}}},
+		{Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("16Gi")}}},
+	}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("2Gi")}}},
+				{Name: "test-container", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}},
+			},
+		},
+	}
+	testcases := []struct {
+		name     string
+		step     config.ResourceStep
+		expected bool
+	}{
+		{
+			name:     "fits on one node",
+			step:     config.ResourceStep{CPURequest: "2", MemRequest: "1Gi"},
+			expected: true,
+		},
+		{
+			name: "does not fit when no single node has both cpu and memory",
+			step: config.ResourceStep{CPURequest: "2", MemRequest: "4Gi"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res := fitsAnyNode(nodes, podRequests(pod, "test-container", testcase.step))
+			assert.Equal(t, testcase.expected, res)
+		})
+	}
+}
+
+func TestPodRequests(t *testing.T) {
+	requests := func(cpu string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}}
+	}
+	testcases := []struct {
+		name     string
+		spec     corev1.PodSpec
+		expected string
+	}{
+		{
+			name:     "add the step to the other containers",
+			spec:     corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: requests("1")}, {Name: "test-container", Resources: requests("100m")}}},
+			expected: "1500m",
+		},
+		{
+			name: "take the largest init container when it needs more than the containers",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init-1", Resources: requests("500m")}, {Name: "init-2", Resources: requests("2")}},
+				Containers:     []corev1.Container{{Name: "app", Resources: requests("1")}},
+			},
+			expected: "2",
+		},
+		{
+			name: "take the containers when they need more than any init container",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init-1", Resources: requests("1200m")}},
+				Containers:     []corev1.Container{{Name: "app", Resources: requests("1")}},
+			},
+			expected: "1500m",
+		},
+		{
+			name: "add the overhead on top",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init-1", Resources: requests("2")}},
+				Containers:     []corev1.Container{{Name: "app", Resources: requests("1")}},
+				Overhead:       corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+			},
+			expected: "2250m",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res := podRequests(&corev1.Pod{Spec: testcase.spec}, "test-container", config.ResourceStep{CPURequest: "500m"})
+			expected := resource.MustParse(testcase.expected)
+			assert.Equal(t, 0, expected.Cmp(res[corev1.ResourceCPU]), "expected %s, got %s", testcase.expected, res.Cpu().String())
+		})
+	}
+}
+
+// TestSettleNodeCapacity puts a step change through settle against the nodes' allocatable capacity, on its own and
+// after the quota has capped it.
+func TestSettleNodeCapacity(t *testing.T) {
+	node := func(cpu string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: v1.ObjectMeta{Name: "test-node"},
+			Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse("8Gi")}},
+		}
+	}
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-quota"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")},
+			Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("500m")},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-pod"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}},
+			{Name: "test-container", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}}},
+		}},
+	}
+	reason := func(step string, cpu string) string {
+		return "no eligible node has allocatable capacity for the pod with step " + step + " (largest allocatable cpu " + cpu + ", memory 8Gi)"
+	}
+	testcases := []struct {
+		name           string
+		policy         config.ConstraintPolicy
+		quotaPolicy    config.ConstraintPolicy
+		objects        []client.Object
+		recommendation corev1.ResourceList
+		expected       dasDetail
+		cpu            any
+		events         []string
+	}{
+		{
+			name:     "hold when no node has room for the pod",
+			policy:   config.ConstraintHold,
+			objects:  []client.Object{node("1100m")},
+			expected: dasDetail{Name: "test-step-1", RestartCount: 2},
+			events:   []string{"Warning StepHeld held test-container on step test-step-1 instead of moving to test-step-2: " + reason("test-step-2", "1100m")},
+		},
+		{
+			name:           "cap to the highest step a node has room for",
+			policy:         config.ConstraintCap,
+			objects:        []client.Object{node("1500m")},
+			recommendation: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("800m")},
+			expected: dasDetail{
+				Name:       "test-step-3",
+				Adjustment: "capped from test-step-4: " + reason("test-step-4", "1500m"),
+				Keys:       []string{"test-container/cpu", "test-container/mem"},
+			},
+			cpu: "400m",
+			events: []string{
+				"Warning StepCapped capped test-container from step test-step-4 to test-step-3: " + reason("test-step-4", "1500m"),
+				"Normal StepChanged moved test-container from step test-step-1 to test-step-3, estimated monthly cost delta 0.00",
+			},
+		},
+		{
+			name:           "hold the step the quota capped to when no node has room for it",
+			policy:         config.ConstraintHold,
+			quotaPolicy:    config.ConstraintCap,
+			objects:        []client.Object{node("1300m"), quota},
+			recommendation: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("800m")},
+			expected:       dasDetail{Name: "test-step-1", RestartCount: 2},
+			events: []string{
+				"Warning StepCapped capped test-container from step test-step-4 to test-step-3: resource quota in test cannot take step test-step-4 across 1 pods",
+				"Warning StepHeld held test-container on step test-step-1 instead of moving to test-step-3: " + reason("test-step-3", "1300m"),
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sidecarConfig := testSidecar(func(sidecarConfig *config.SidecarConfig) {
+				sidecarConfig.NodeCapacityPolicy = testcase.policy
+				sidecarConfig.QuotaPolicy = testcase.quotaPolicy
+			})
+			res := settleRestart(t, settleCase{
+				conf:           config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}},
+				pod:            pod,
+				recommendation: testcase.recommendation,
+				objects:        testcase.objects,
+			})
+			assert.Equal(t, map[string]dasDetail{"test-container": testcase.expected}, res.details)
+			assert.Equal(t, testcase.cpu, res.podAnnotations["test-container/cpu"])
+			assert.Equal(t, testcase.events, res.events)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// nodeClassSeparator joins a container name and its node class in the das details key.
+// containers on the default ladder are keyed by name alone, so existing das details keep working.
+const nodeClassSeparator string = "@"
+
+func detailKey(containerName string, nodeClass string) string {
+	if nodeClass == "" {
+		return containerName
+	}
+	return containerName + nodeClassSeparator + nodeClass
+}
+
+func splitDetailKey(key string) (string, string) {
+	containerName, nodeClass, _ := strings.Cut(key, nodeClassSeparator)
+	return containerName, nodeClass
+}
+
+// sidecarForKey returns the sidecar config for a das details key of the workload, with the steps of its node class in place.
+func sidecarForKey(conf config.Config, key string, workload config.Workload) (config.SidecarConfig, bool) {
+	containerName, nodeClass := splitDetailKey(key)
+	_, sidecarConfig, ok := conf.SidecarFor(containerName, workload)
+	if !ok {
+		return sidecarConfig, false
+	}
+	return withNodeClass(sidecarConfig, nodeClass)
+}
+
+// ownSteps returns the sidecar's own steps for a container of the workload, which floor rules name their steps on.
+func ownSteps(conf config.Config, containerName string, workload config.Workload) []config.ResourceStep {
+	_, sidecarConfig, _ := conf.SidecarFor(containerName, workload)
+	return sidecarConfig.Steps
+}
+
+// ownerSidecarForKey is sidecarForKey with the owner's das/ladder and das/overrides applied on top.
+func ownerSidecarForKey(conf config.Config, key string, workload config.Workload, ownerAnnotations map[string]string) (config.SidecarConfig, bool) {
+	sidecarConfig, ok := sidecarForKey(conf, key, workload)
+	if !ok {
+		return sidecarConfig, false
+	}
+	containerName, _ := splitDetailKey(key)
+	return ownerSidecarConfig(conf, sidecarConfig, containerName, ownerAnnotations), true
+}
+
+func withNodeClass(sidecarConfig config.SidecarConfig, nodeClass string) (config.SidecarConfig, bool) {
+	if nodeClass == "" {
+		return sidecarConfig, true
+	}
+	for _, ladder := range sidecarConfig.NodePoolLadders {
+		if ladder.Name == nodeClass {
+			sidecarConfig.Steps = ladder.Steps
+			return sidecarConfig, true
+		}
+	}
+	return sidecarConfig, false
+}
+
+// nodeLabels returns the labels of the node the pod ran on. nil is returned if the pod was never scheduled
+// or the node is gone, in which case ladders are picked from what the pod asked for instead.
+func (r *PodReconciler) nodeLabels(ctx context.Context, pod *corev1.Pod) map[string]string {
+	if pod.Spec.NodeName == "" || !r.hasNodePoolLadders() {
+		return nil
+	}
+	var node corev1.Node
+	err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, &node)
+	if err != nil {
+		slog.Warn("error getting node for node pool ladders", "node_name", pod.Spec.NodeName, "err", err.Error())
+		return nil
+	}
+	return node.Labels
+}
+
+func (r *PodReconciler) hasNodePoolLadders() bool {
+	for _, sidecarConfig := range r.conf.Load().Sidecars {
+		if len(sidecarConfig.NodePoolLadders) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// selectLadders swaps in the node pool ladder for each container whose pod is on, or pinned to, a matching node.
+// the first matching ladder in config order wins.
+func (p PodOwnerModifier) selectLadders(details []containerDetail, pod *corev1.Pod, nodeLabels map[string]string) []containerDetail {
+	labels := nodeLabels
+	if labels == nil {
+		labels = pinnedLabels(pod)
+	}
+	res := make([]containerDetail, 0, len(details))
+	for _, detail := range details {
+		for _, ladder := range detail.sidecarConfig.NodePoolLadders {
+			if len(ladder.NodeSelector) == 0 || !matchesLabels(ladder.NodeSelector, labels) {
+				continue
+			}
+			slog.Debug("using node pool ladder", "container_name", detail.containerStatus.Name, "node_class", ladder.Name)
+			detail.sidecarConfig.Steps = ladder.Steps
+			detail.nodeClass = ladder.Name
+			break
+		}
+		res = append(res, detail)
+	}
+	return res
+}
+
+// withNodePoolLadders picks the node pool ladder for each container from the node the pod that restarted ran on.
+func (r *PodReconciler) withNodePoolLadders(ctx context.Context, target ownerTarget, details []containerDetail) []containerDetail {
+	if target.pod == nil || !r.hasNodePoolLadders() {
+		return details
+	}
+	return r.modifier.selectLadders(details, target.pod, r.nodeLabels(ctx, target.pod))
+}
+
+// coverNodeClasses raises the annotations written for a container das tracks on several node classes to cover the
+// step of every class. the annotations are shared by all the owner's pods, so each resource gets the largest value
+// across the classes and every pod gets at least what the step of its own node class asks for.
+func (r *PodReconciler) coverNodeClasses(ctx context.Context, target ownerTarget, res *newAnnotations) {
+	if len(res.decisions) == 0 || !r.hasNodePoolLadders() {
+		return
+	}
+	conf := r.conf.Load()
+	keys := make([]string, 0, len(res.dasDetails))
+	for key := range res.dasDetails {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	var workload *config.Workload
+	for i, d := range res.decisions {
+		if d.held {
+			continue
+		}
+		key := detailKey(d.container, d.nodeClass)
+		covered := d.to
+		var classes []string
+		for _, other := range keys {
+			containerName, nodeClass := splitDetailKey(other)
+			if containerName != d.container || other == key {
+				continue
+			}
+			if workload == nil {
+				workload = &config.Workload{NamespaceLabels: r.namespaceLabels(ctx, target.namespacedName.Namespace), PodLabels: target.podTemplate.Labels}
+			}
+			sidecarConfig, ok := ownerSidecarForKey(conf, other, *workload, res.ownerAnnotations)
+			if !ok {
+				continue
+			}
+			detail := res.dasDetails[other]
+			applied := detail.Name
+			if detail.Floor != "" {
+				applied = detail.Floor
+			}
+			j := slices.IndexFunc(sidecarConfig.Steps, func(step config.ResourceStep) bool { return step.Name == applied })
+			if j == -1 {
+				continue
+			}
+			var raised bool
+			covered, raised = coverSteps(covered, sidecarConfig.Steps[j])
+			if raised {
+				classes = append(classes, nodeClassName(nodeClass))
+			}
+		}
+		if len(classes) == 0 {
+			continue
+		}
+		slog.Info("raising annotations to cover other node classes", "container_name", d.container, "node_class", d.nodeClass, "step_name", d.to.Name, "covered_node_classes", classes)
+		r.modifier.coverStep(res, i, covered, fmt.Sprintf("annotations cover node classes %s", strings.Join(classes, ", ")))
+	}
+}
+
+// nodeClassName names a node class in messages, where the sidecar's own steps have no name.
+func nodeClassName(nodeClass string) string {
+	if nodeClass == "" {
+		return "default"
+	}
+	return nodeClass
+}
+
+func matchesLabels(selector map[string]string, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// pinnedLabels are the node labels a pod is certain to get from its nodeSelector and required node affinity.
+// affinity only counts when there is a single term, since terms are ORed, and only for In with a single value.
+func pinnedLabels(pod *corev1.Pod) map[string]string {
+	res := make(map[string]string)
+	for k, v := range pod.Spec.NodeSelector {
+		res[k] = v
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return res
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 {
+		return res
+	}
+	for _, expr := range terms[0].MatchExpressions {
+		if expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+			res[expr.Key] = expr.Values[0]
+		}
+	}
+	return res
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestSelectLadders(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Steps: []config.ResourceStep{{Name: "x86-small"}},
+		NodePoolLadders: []config.NodePoolLadder{
+			{Name: "arm", NodeSelector: map[string]string{"kubernetes.io/arch": "arm64"}, Steps: []config.ResourceStep{{Name: "arm-small"}}},
+		},
+	}
+	details := []containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}}}
+	affinityPod := &corev1.Pod{Spec: corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"arm64"}}},
+		}}},
+	}}}}
+	testcases := []struct {
+		name       string
+		pod        *corev1.Pod
+		nodeLabels map[string]string
+		nodeClass  string
+		stepName   string
+	}{
+		{
+			name:       "node labels pick the ladder",
+			pod:        &corev1.Pod{},
+			nodeLabels: map[string]string{"kubernetes.io/arch": "arm64"},
+			nodeClass:  "arm",
+			stepName:   "arm-small",
+		},
+		{
+			name:       "node labels take precedence over the node selector",
+			pod:        &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/arch": "arm64"}}},
+			nodeLabels: map[string]string{"kubernetes.io/arch": "amd64"},
+			stepName:   "x86-small",
+		},
+		{
+			name:      "node selector picks the ladder without a node",
+			pod:       &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/arch": "arm64"}}},
+			nodeClass: "arm",
+			stepName:  "arm-small",
+		},
+		{
+			name:      "required node affinity picks the ladder without a node",
+			pod:       affinityPod,
+			nodeClass: "arm",
+			stepName:  "arm-small",
+		},
+		{
+			name:     "sidecar steps without a match",
+			pod:      &corev1.Pod{},
+			stepName: "x86-small",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+			res := m.selectLadders(details, testcase.pod, testcase.nodeLabels)
+			assert.Equal(t, testcase.nodeClass, res[0].nodeClass)
+			assert.Equal(t, testcase.stepName, res[0].sidecarConfig.Steps[0].Name)
+		})
+	}
+}
+
+func TestNewAnnotationsPerNodeClass(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		CPUAnnotationKey: "cpu",
+		Steps:            []config.ResourceStep{{Name: "arm-small", RestartLimit: 1}, {Name: "arm-large", CPURequest: "2", RestartLimit: 1}},
+	}
+	current, _ := json.Marshal(map[string]dasDetail{
+		"test-container":     {Name: "x86-large"},
+		"test-container@arm": {Name: "arm-small"},
+	})
+	m := NewPodOwnerModifier(config.NewStore(config.Config{}))
+	details := []containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}, nodeClass: "arm"}}
+	res, err := m.newAnnotations(details, map[string]string{dasDetailsKey: string(current)}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, dasDetail{Name: "x86-large"}, res.dasDetails["test-container"])
+	assert.Equal(t, dasDetail{Name: "arm-large", Keys: []string{"cpu"}}, res.dasDetails["test-container@arm"])
+	assert.Equal(t, "arm", res.decisions[0].nodeClass)
+	assert.Equal(t, "2", res.podAnnotations["cpu"])
+}
+
+// TestCoverNodeClasses steps up a container on one node class of an owner spanning two, and checks the shared
+// annotations still cover the step of the other class.
+func TestCoverNodeClasses(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		ErrCodes:         []int{137},
+		CPUAnnotationKey: "test-container/cpu",
+		MemAnnotationKey: "test-container/mem",
+		Steps: []config.ResourceStep{
+			{Name: "x86-small", RestartLimit: 1, CPURequest: "500m", MemRequest: "1Gi"},
+			{Name: "x86-large", RestartLimit: 1, CPURequest: "1", MemRequest: "4Gi"},
+		},
+		NodePoolLadders: []config.NodePoolLadder{{
+			Name:         "arm",
+			NodeSelector: map[string]string{"kubernetes.io/arch": "arm64"},
+			Steps: []config.ResourceStep{
+				{Name: "arm-small", RestartLimit: 1, CPURequest: "1", MemRequest: "1Gi"},
+				{Name: "arm-large", RestartLimit: 1, CPURequest: "2", MemRequest: "2Gi"},
+			},
+		}},
+	}
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}}
+	current, _ := json.Marshal(map[string]dasDetail{
+		"test-container":     {Name: "x86-large"},
+		"test-container@arm": {Name: "arm-small"},
+	})
+	deployment := &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace:   "test",
+			Name:        "test-deployment",
+			Annotations: map[string]string{dasDetailsKey: string(current), "test-container/cpu": "1", "test-container/mem": "4Gi"},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-pod"},
+		Spec:       corev1.PodSpec{NodeName: "arm-1"},
+	}
+	node := &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "arm-1", Labels: map[string]string{"kubernetes.io/arch": "arm64"}}}
+	var sent map[string]any
+	c := fake.NewClientBuilder().
+		WithObjects(deployment, pod, node).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				data, err := patch.Data(obj)
+				if err != nil {
+					return err
+				}
+				return json.Unmarshal(data, &sent)
+			},
+		}).
+		Build()
+	store := config.NewStore(conf)
+	r := NewPodReconciler(c, store, NewPodOwnerModifier(store), nil, nil)
+	target := ownerTarget{
+		kind:           config.Deployment,
+		namespacedName: types.NamespacedName{Namespace: "test", Name: "test-deployment"},
+		object:         deployment,
+		replicas:       1,
+		annotations:    deployment.Annotations,
+		pod:            pod,
+	}
+	details := []containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}}}
+
+	res, err := r.commit(context.Background(), target, "test-app", details)
+	assert.NoError(t, err)
+	assert.Equal(t, "arm-large", res.steps["test-container@arm"].Name)
+
+	annotations := sent["metadata"].(map[string]any)["annotations"].(map[string]any)
+	// the cpu of the arm step, and the memory of the x86 step
+	assert.Equal(t, "2", annotations["test-container/cpu"])
+	assert.Equal(t, "4Gi", annotations["test-container/mem"])
+	var updated map[string]dasDetail
+	assert.NoError(t, json.Unmarshal([]byte(annotations[dasDetailsKey].(string)), &updated))
+	assert.Equal(t, "x86-large", updated["test-container"].Name)
+	assert.Equal(t, "arm-large", updated["test-container@arm"].Name)
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/bento01dev/das/internal/config"
+)
+
+const (
+	// overridesAnnotationKey is the owner annotation with per container overrides of the sidecar config.
+	overridesAnnotationKey string = "das/overrides"
+	// ladderAnnotationKey is the owner annotation naming one of the config's ladders to use for every sidecar.
+	ladderAnnotationKey string = "das/ladder"
+)
+
+// withWorkloadLadder swaps the steps of each container for the ladder the owner picked. a ladder that is not in the
+// config or breaks a sidecar's guardrails is ignored for that sidecar with a warning event on the owner.
+func (r *PodReconciler) withWorkloadLadder(target ownerTarget, details []containerDetail) []containerDetail {
+	name, ok := target.annotations[ladderAnnotationKey]
+	if !ok || name == "" {
+		return details
+	}
+	conf := r.conf.Load()
+	res := make([]containerDetail, 0, len(details))
+	for _, d := range details {
+		sidecarConfig, err := conf.WithWorkloadLadder(d.sidecarConfig, name)
+		if err != nil {
+			slog.Warn("ignoring das ladder", "owner_kind", target.kind, "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace, "container_name", d.containerStatus.Name, "ladder", name, "err", err.Error())
+			r.event(target, "LadderRejected", "ignored %s for %s: %v", ladderAnnotationKey, d.containerStatus.Name, err)
+			res = append(res, d)
+			continue
+		}
+		d.sidecarConfig = sidecarConfig
+		res = append(res, d)
+	}
+	return res
+}
+
+// withOverrides merges the owner's overrides into the sidecar config of each container.
+// an override that cannot be read or breaks the sidecar's guardrails is ignored with a warning event on the owner,
+// and the container keeps the sidecar config as is.
+func (r *PodReconciler) withOverrides(target ownerTarget, details []containerDetail) []containerDetail {
+	overrides, err := parseOverrides(target.annotations)
+	if err != nil {
+		slog.Warn("error parsing das overrides", "owner_kind", target.kind, "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace, "err", err.Error())
+		r.event(target, "OverridesInvalid", "ignored %s: %v", overridesAnnotationKey, err)
+		return details
+	}
+	if overrides == nil {
+		return details
+	}
+	res := make([]containerDetail, 0, len(details))
+	for _, d := range details {
+		override, ok := overrides[d.containerStatus.Name]
+		if !ok {
+			res = append(res, d)
+			continue
+		}
+		sidecarConfig, err := d.sidecarConfig.WithOverride(override)
+		if err != nil {
+			slog.Warn("ignoring das override", "owner_kind", target.kind, "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace, "container_name", d.containerStatus.Name, "err", err.Error())
+			r.event(target, "OverrideRejected", "ignored %s for %s: %v", overridesAnnotationKey, d.containerStatus.Name, err)
+			res = append(res, d)
+			continue
+		}
+		d.sidecarConfig = sidecarConfig
+		res = append(res, d)
+	}
+	return res
+}
+
+// parseOverrides reads the das/overrides annotation. nil is returned when the owner has none.
+func parseOverrides(annotations map[string]string) (map[string]config.Override, error) {
+	raw, ok := annotations[overridesAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	overrides := make(map[string]config.Override)
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// ownerSidecarConfig is the sidecar config an owner runs the container with, after its das/ladder and das/overrides.
+// a ladder or override that cannot be used is left out, as it is when a restart ignores it with a warning event,
+// so that the floor sweep and the budget tally work from the same ladder as restarts.
+func ownerSidecarConfig(conf config.Config, sidecarConfig config.SidecarConfig, containerName string, annotations map[string]string) config.SidecarConfig {
+	if name := annotations[ladderAnnotationKey]; name != "" {
+		if withLadder, err := conf.WithWorkloadLadder(sidecarConfig, name); err == nil {
+			sidecarConfig = withLadder
+		}
+	}
+	overrides, err := parseOverrides(annotations)
+	if err != nil {
+		return sidecarConfig
+	}
+	if override, ok := overrides[containerName]; ok {
+		if withOverride, err := sidecarConfig.WithOverride(override); err == nil {
+			sidecarConfig = withOverride
+		}
+	}
+	return sidecarConfig
+}
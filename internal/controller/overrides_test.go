@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestWorkloadLadderMapsLearntStep runs a learnt step through a switch to another ladder and checks it lands on the new ladder
+// from what is running rather than jumping to its top.
+func TestWorkloadLadderMapsLearntStep(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		CPUAnnotationKey: "test-cpu-request-key",
+		Guardrails:       config.Guardrails{AllowOverrides: true},
+		Steps: []config.ResourceStep{
+			{Name: "small", RestartLimit: 3, CPURequest: "100m"},
+			{Name: "medium", RestartLimit: 3, CPURequest: "200m"},
+		},
+	}
+	conf := config.Config{
+		Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig},
+		Ladders: map[string][]config.ResourceStep{"big": {
+			{Name: "big-1", RestartLimit: 3, CPURequest: "150m"},
+			{Name: "big-2", RestartLimit: 3, CPURequest: "500m"},
+			{Name: "big-3", RestartLimit: 3, CPURequest: "1"},
+		}},
+	}
+	store := config.NewStore(conf)
+	m := NewPodOwnerModifier(store)
+	r := NewPodReconciler(nil, store, m, nil, nil)
+	target := ownerTarget{
+		kind:        config.Deployment,
+		annotations: map[string]string{ladderAnnotationKey: "big", dasDetailsKey: `{"test-container":{"name":"medium","restart_count":2}}`},
+		podTemplate: corev1.PodTemplateSpec{ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{"test-cpu-request-key": "200m"}}},
+	}
+
+	details := r.withWorkloadLadder(target, []containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}}})
+	res, err := m.newAnnotations(details, target.annotations, target.podTemplate.Annotations)
+	assert.NoError(t, err)
+	assert.Equal(t, dasDetail{Name: "big-1", RestartCount: 1}, res.dasDetails["test-container"])
+	assert.Empty(t, res.decisions)
+	assert.Equal(t, "200m", res.podAnnotations["test-cpu-request-key"])
+}
+
+// TestSettleOverridesBudget puts a step change on a ladder the owner picked through settle against the budget.
+// what das has handed out is counted from the sidecar's own first step, so a ladder or start step above it
+// takes up budget before the change does.
+func TestSettleOverridesBudget(t *testing.T) {
+	testcases := []struct {
+		name        string
+		step        string
+		annotations map[string]string
+		budget      string
+		expected    dasDetail
+		cpu         any
+		events      []string
+	}{
+		{
+			name:        "step up the picked ladder when the budget has room from the sidecar's first step",
+			step:        "big-1",
+			annotations: map[string]string{ladderAnnotationKey: "big"},
+			budget:      "400m",
+			expected:    dasDetail{Name: "big-2", Keys: []string{"test-container/cpu", "test-container/mem"}},
+			cpu:         "500m",
+			events:      []string{"Normal StepChanged moved test-container from step big-1 to big-2, estimated monthly cost delta 0.00"},
+		},
+		{
+			name:        "hold on the picked ladder when its first step has taken the room",
+			step:        "big-1",
+			annotations: map[string]string{ladderAnnotationKey: "big"},
+			budget:      "350m",
+			expected:    dasDetail{Name: "big-1", RestartCount: 2},
+			events:      []string{"Warning StepHeld held test-container on step big-1 instead of moving to big-2: step big-2 would take das over its cluster budget"},
+		},
+		{
+			name:        "hold above an overridden start step when it has taken the room",
+			step:        "test-step-2",
+			annotations: map[string]string{overridesAnnotationKey: `{"test-container":{"start_step":"test-step-2"}}`},
+			budget:      "250m",
+			expected:    dasDetail{Name: "test-step-2", RestartCount: 2},
+			events:      []string{"Warning StepHeld held test-container on step test-step-2 instead of moving to test-step-3: step test-step-3 would take das over its cluster budget"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sidecarConfig := testSidecar(func(sidecarConfig *config.SidecarConfig) {
+				sidecarConfig.Guardrails = config.Guardrails{AllowOverrides: true}
+			})
+			res := settleRestart(t, settleCase{
+				conf: config.Config{
+					Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig},
+					Ladders: map[string][]config.ResourceStep{"big": {
+						{Name: "big-1", RestartLimit: 1, CPURequest: "150m"},
+						{Name: "big-2", RestartLimit: 1, CPURequest: "500m"},
+						{Name: "big-3", RestartLimit: 1, CPURequest: "1"},
+					}},
+					Budget: &config.BudgetConfig{CPU: testcase.budget, Policy: config.ConstraintHold},
+				},
+				step:        testcase.step,
+				annotations: testcase.annotations,
+			})
+			assert.Equal(t, map[string]dasDetail{"test-container": testcase.expected}, res.details)
+			assert.Equal(t, testcase.cpu, res.podAnnotations["test-container/cpu"])
+			assert.Equal(t, testcase.events, res.events)
+		})
+	}
+}
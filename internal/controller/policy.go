@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bento01dev/das/internal/api/v1alpha1"
+	"github.com/bento01dev/das/internal/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// podContainerField indexes pods by the names of their containers, so a policy's status only looks at the pods
+// that can match it.
+const podContainerField string = "spec.containers.name"
+
+// policyStatusInterval is how often the status of each sidecar policy is refreshed, mostly for the matched workload count.
+const policyStatusInterval time.Duration = 5 * time.Minute
+
+// policySidecars works out the sidecars sidecar policies add to the base config, keyed by policy name,
+// and why any policy is not used. a policy is not used if it is invalid, or if the config file has a sidecar
+// under the same key, since the config file takes precedence.
+func policySidecars(base config.Config, policies []v1alpha1.SidecarPolicy) (map[string]config.SidecarConfig, map[string][]string) {
+	sidecars := make(map[string]config.SidecarConfig)
+	policyErrs := make(map[string][]string)
+	for _, policy := range policies {
+		if _, ok := base.Sidecars[policy.Name]; ok {
+			policyErrs[policy.Name] = append(policyErrs[policy.Name], fmt.Sprintf("sidecar %s is already configured in the config file", policy.Name))
+			continue
+		}
+		sidecarConfig, err := base.WithLadder(policy.Spec.SidecarConfig)
+		if err == nil {
+			sidecarConfig, err = sidecarConfig.WithProfile()
+		}
+		if err != nil {
+			policyErrs[policy.Name] = append(policyErrs[policy.Name], fmt.Sprintf("sidecar %s: %s", policy.Name, err.Error()))
+			continue
+		}
+		if sidecarConfig.Container == "" && sidecarConfig.ContainerRegex == "" {
+			sidecarConfig.Container = policy.ContainerName()
+		}
+		err = config.Config{Sidecars: map[string]config.SidecarConfig{policy.Name: sidecarConfig}, Ladders: base.Ladders}.Validate()
+		if err != nil {
+			policyErrs[policy.Name] = append(policyErrs[policy.Name], strings.Split(err.Error(), "\n")...)
+			continue
+		}
+		sidecars[policy.Name] = sidecarConfig
+	}
+	return sidecars, policyErrs
+}
+
+// policyWatcher keeps the sidecars from sidecar policies in the config store up to date.
+// like the config reload, it runs on every replica and not just the leader.
+type policyWatcher struct {
+	cache cache.Cache
+	store *config.Store
+}
+
+func (w policyWatcher) Start(ctx context.Context) error {
+	// policies are worked out against the base config, so a config reload needs a sync too
+	reloads := w.store.Reloads()
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	for _, obj := range []client.Object{&v1alpha1.SidecarPolicy{}, &v1alpha1.TeamSidecarPolicy{}, &v1alpha1.SidecarGuardrail{}} {
+		informer, err := w.cache.GetInformer(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("error getting informer for %T: %w", obj, err)
+		}
+		_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj any) { notify() },
+			UpdateFunc: func(oldObj, newObj any) { notify() },
+			DeleteFunc: func(obj any) { notify() },
+		})
+		if err != nil {
+			return fmt.Errorf("error watching %T: %w", obj, err)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+			w.sync(ctx)
+		case <-reloads:
+			w.sync(ctx)
+		}
+	}
+}
+
+func (w policyWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w policyWatcher) sync(ctx context.Context) {
+	var (
+		policies     v1alpha1.SidecarPolicyList
+		teamPolicies v1alpha1.TeamSidecarPolicyList
+		guardrails   v1alpha1.SidecarGuardrailList
+	)
+	for _, list := range []client.ObjectList{&policies, &teamPolicies, &guardrails} {
+		if err := w.cache.List(ctx, list); err != nil {
+			slog.Error("error listing policies. keeping the sidecars in use", "err", err.Error())
+			return
+		}
+	}
+	sidecars, policyErrs := policySidecars(w.store.Base(), policies.Items)
+	teamSidecars := teamPolicySidecars(w.store.Base(), guardrails.Items, teamPolicies.Items)
+	for key, sidecarConfig := range teamSidecars {
+		sidecars[key] = sidecarConfig
+	}
+	w.store.SetOverlay(sidecars)
+	slog.Info("sidecar policies synced", "active", len(sidecars)-len(teamSidecars), "inactive", len(policyErrs), "active_team", len(teamSidecars), "inactive_team", len(teamPolicies.Items)-len(teamSidecars))
+}
+
+// SidecarPolicyReconciler reports in the status of each sidecar policy whether das uses it and how many workloads it matches.
+type SidecarPolicyReconciler struct {
+	client.Client
+	store *config.Store
+}
+
+func (r *SidecarPolicyReconciler) SetupWithManager(manager ctrl.Manager) error {
+	err := manager.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podContainerField, podContainerNames)
+	if err != nil {
+		return fmt.Errorf("error indexing pods by container name: %w", err)
+	}
+	// the matched workloads of a policy depend on the other policies for the same container, so every change looks at all of them
+	return ctrl.NewControllerManagedBy(manager).
+		Named("sidecarpolicy").
+		Watches(&v1alpha1.SidecarPolicy{}, handler.EnqueueRequestsFromMapFunc(r.allPolicies), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WatchesRawSource(reloadSource(r.store, r.allPolicies)).
+		Complete(r)
+}
+
+// reloadSource queues the policies from requests whenever the config file is reloaded, since their status is
+// worked out against the base config.
+func reloadSource(store *config.Store, requests handler.MapFunc) source.Source {
+	return source.Func(func(ctx context.Context, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) error {
+		reloads := store.Reloads()
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-reloads:
+					for _, req := range requests(ctx, nil) {
+						queue.Add(req)
+					}
+				}
+			}
+		}()
+		return nil
+	})
+}
+
+func (r *SidecarPolicyReconciler) allPolicies(ctx context.Context, _ client.Object) []reconcile.Request {
+	var policies v1alpha1.SidecarPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		slog.Error("error listing sidecar policies", "err", err.Error())
+		return nil
+	}
+	res := make([]reconcile.Request, 0, len(policies.Items))
+	for _, policy := range policies.Items {
+		res = append(res, reconcile.Request{NamespacedName: types.NamespacedName{Name: policy.Name}})
+	}
+	return res
+}
+
+func (r *SidecarPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var policy v1alpha1.SidecarPolicy
+	err := r.Get(ctx, req.NamespacedName, &policy)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("error getting sidecar policy %s: %w", req.Name, err)
+	}
+	var policies v1alpha1.SidecarPolicyList
+	err = r.List(ctx, &policies)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error listing sidecar policies: %w", err)
+	}
+	_, policyErrs := policySidecars(r.store.Base(), policies.Items)
+	matched := 0
+	if len(policyErrs[policy.Name]) == 0 {
+		matched, err = r.matchedWorkloads(ctx, policy.Name)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	status := v1alpha1.SidecarPolicyStatus{
+		ObservedGeneration: policy.Generation,
+		Active:             len(policyErrs[policy.Name]) == 0,
+		MatchedWorkloads:   matched,
+		Errors:             policyErrs[policy.Name],
+	}
+	if !equality.Semantic.DeepEqual(policy.Status, status) {
+		policy.Status = status
+		err = r.Status().Update(ctx, &policy)
+		if err != nil {
+			slog.Error("error updating sidecar policy status", "policy_name", policy.Name, "err", err.Error())
+			return ctrl.Result{}, fmt.Errorf("error updating status of sidecar policy %s: %w", policy.Name, err)
+		}
+	}
+	return ctrl.Result{RequeueAfter: policyStatusInterval}, nil
+}
+
+// matchedWorkloads counts the deployments and daemon sets with running pods that the sidecar config under key applies to.
+func (r *SidecarPolicyReconciler) matchedWorkloads(ctx context.Context, key string) (int, error) {
+	conf := r.store.Load()
+	if _, ok := conf.Sidecars[key]; !ok {
+		return 0, nil
+	}
+	var opts []client.ListOption
+	if !conf.IsPattern(key) {
+		opts = append(opts, client.MatchingFields{podContainerField: conf.ContainerName(key)})
+	}
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, opts...); err != nil {
+		return 0, fmt.Errorf("error listing pods for sidecar policy status: %w", err)
+	}
+	if len(pods.Items) == 0 {
+		return 0, nil
+	}
+	var namespaces corev1.NamespaceList
+	if err := r.List(ctx, &namespaces); err != nil {
+		return 0, fmt.Errorf("error listing namespaces for sidecar policy status: %w", err)
+	}
+	namespaceLabels := make(map[string]map[string]string)
+	for _, ns := range namespaces.Items {
+		namespaceLabels[ns.Name] = ns.Labels
+	}
+	workloads := make(map[string]bool)
+	for _, pod := range pods.Items {
+		workload := config.Workload{NamespaceLabels: namespaceLabels[pod.Namespace], PodLabels: pod.Labels}
+		matched := slices.ContainsFunc(pod.Spec.Containers, func(container corev1.Container) bool {
+			if !conf.MatchesContainer(key, container.Name) {
+				return false
+			}
+			matchedKey, _, ok := conf.SidecarFor(container.Name, workload)
+			return ok && matchedKey == key
+		})
+		if !matched {
+			continue
+		}
+		owner := workloadOf(pod)
+		if owner == "" {
+			continue
+		}
+		workloads[pod.Namespace+"/"+owner] = true
+	}
+	return len(workloads), nil
+}
+
+func podContainerNames(obj client.Object) []string {
+	pod := obj.(*corev1.Pod)
+	names := make([]string, 0, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+// workloadOf returns the kind and name of the deployment or daemon set controlling the pod.
+// the deployment is worked out from the replica set name, so it is not looked up.
+func workloadOf(pod corev1.Pod) string {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller {
+			continue
+		}
+		switch ref.Kind {
+		case "ReplicaSet":
+			hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+			return string(config.Deployment) + "/" + strings.TrimSuffix(ref.Name, "-"+hash)
+		case "DaemonSet":
+			return string(config.DaemonSet) + "/" + ref.Name
+		}
+	}
+	return ""
+}
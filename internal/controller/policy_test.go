@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bento01dev/das/internal/api/v1alpha1"
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPolicySidecars(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		CPUAnnotationKey: "test-sidecar/cpu",
+		Steps:            []config.ResourceStep{{Name: "test-step-1", CPURequest: "1"}},
+	}
+	policy := func(name string, container string, sidecarConfig config.SidecarConfig) v1alpha1.SidecarPolicy {
+		sidecarConfig.Container = container
+		return v1alpha1.SidecarPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.SidecarPolicySpec{SidecarConfig: sidecarConfig},
+		}
+	}
+	invalid := sidecarConfig
+	invalid.Steps = nil
+	base := config.Config{Sidecars: map[string]config.SidecarConfig{"file-sidecar": sidecarConfig}}
+
+	testcases := []struct {
+		name             string
+		policies         []v1alpha1.SidecarPolicy
+		expectedSidecars []string
+		expectedErrs     map[string][]string
+	}{
+		{
+			name:             "container defaults to the policy name",
+			policies:         []v1alpha1.SidecarPolicy{policy("test-sidecar", "", sidecarConfig)},
+			expectedSidecars: []string{"test-sidecar"},
+			expectedErrs:     map[string][]string{},
+		},
+		{
+			name:         "config file takes precedence",
+			policies:     []v1alpha1.SidecarPolicy{policy("file-sidecar", "", sidecarConfig)},
+			expectedErrs: map[string][]string{"file-sidecar": {"sidecar file-sidecar is already configured in the config file"}},
+		},
+		{
+			name:             "several policies for the same container",
+			policies:         []v1alpha1.SidecarPolicy{policy("prod-sidecar", "test-sidecar", sidecarConfig), policy("dev-sidecar", "test-sidecar", sidecarConfig)},
+			expectedSidecars: []string{"prod-sidecar", "dev-sidecar"},
+			expectedErrs:     map[string][]string{},
+		},
+		{
+			name:         "invalid policy",
+			policies:     []v1alpha1.SidecarPolicy{policy("test-sidecar", "", invalid)},
+			expectedErrs: map[string][]string{"test-sidecar": {"sidecar test-sidecar: steps must not be empty"}},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sidecars, policyErrs := policySidecars(base, testcase.policies)
+			var names []string
+			for name := range sidecars {
+				names = append(names, name)
+			}
+			assert.ElementsMatch(t, testcase.expectedSidecars, names)
+			assert.Equal(t, testcase.expectedErrs, policyErrs)
+		})
+	}
+}
+
+func TestTeamPolicySidecars(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		CPUAnnotationKey: "test-sidecar/cpu",
+		Steps:            []config.ResourceStep{{Name: "test-step-1", CPURequest: "1"}, {Name: "test-step-2", CPURequest: "4"}},
+	}
+	teamPolicy := func(namespace string, name string) v1alpha1.TeamSidecarPolicy {
+		sidecarConfig := sidecarConfig
+		sidecarConfig.Container = "test-sidecar"
+		return v1alpha1.TeamSidecarPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       v1alpha1.SidecarPolicySpec{SidecarConfig: sidecarConfig},
+		}
+	}
+	guardrail := func(name string, container string, action config.GuardrailAction) v1alpha1.SidecarGuardrail {
+		return v1alpha1.SidecarGuardrail{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1alpha1.SidecarGuardrailSpec{
+				Container:        container,
+				ClusterGuardrail: config.ClusterGuardrail{MaxCPU: "2", Action: action},
+			},
+		}
+	}
+
+	testcases := []struct {
+		name                string
+		guardrails          []v1alpha1.SidecarGuardrail
+		expectedSidecars    []string
+		expectedErrs        []string
+		expectedAdjustments int
+	}{
+		{
+			name:         "no guardrail for the container",
+			guardrails:   []v1alpha1.SidecarGuardrail{guardrail("other", "other-sidecar", config.GuardrailClamp)},
+			expectedErrs: []string{"no sidecar guardrail for container test-sidecar"},
+		},
+		{
+			name:         "rejected by the guardrail",
+			guardrails:   []v1alpha1.SidecarGuardrail{guardrail("test-guardrail", "test-sidecar", config.GuardrailReject)},
+			expectedErrs: []string{"sidecar guardrail test-guardrail: step test-step-2: cpu_request 4 is above the guardrail of 2"},
+		},
+		{
+			name:                "clamped by the guardrail",
+			guardrails:          []v1alpha1.SidecarGuardrail{guardrail("test-guardrail", "test-sidecar", config.GuardrailClamp)},
+			expectedSidecars:    []string{"team-a/test-policy"},
+			expectedAdjustments: 1,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			policy := teamPolicy("team-a", "test-policy")
+			result := teamPolicySidecar(config.Config{}, testcase.guardrails, policy)
+			assert.Equal(t, testcase.expectedErrs, result.errs)
+			assert.Len(t, result.adjustments, testcase.expectedAdjustments)
+
+			sidecars := teamPolicySidecars(config.Config{}, testcase.guardrails, []v1alpha1.TeamSidecarPolicy{policy})
+			var names []string
+			for name, sidecar := range sidecars {
+				names = append(names, name)
+				assert.Equal(t, "test-sidecar", sidecar.Container)
+				assert.True(t, sidecar.Matches(config.Workload{NamespaceLabels: map[string]string{"kubernetes.io/metadata.name": "team-a"}}))
+				assert.False(t, sidecar.Matches(config.Workload{NamespaceLabels: map[string]string{"kubernetes.io/metadata.name": "team-b"}}))
+			}
+			assert.ElementsMatch(t, testcase.expectedSidecars, names)
+		})
+	}
+}
+
+func TestTeamPolicySidecarLadders(t *testing.T) {
+	base := config.Config{Ladders: map[string][]config.ResourceStep{
+		"small": {{Name: "small-1", RestartLimit: 1, CPURequest: "1"}},
+		"big":   {{Name: "big-1", RestartLimit: 1, CPURequest: "1"}, {Name: "big-2", RestartLimit: 1, CPURequest: "8"}},
+	}}
+	guardrails := []v1alpha1.SidecarGuardrail{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-guardrail"},
+		Spec:       v1alpha1.SidecarGuardrailSpec{Container: "test-sidecar", ClusterGuardrail: config.ClusterGuardrail{MaxCPU: "2"}},
+	}}
+	policy := func(ladder string) v1alpha1.TeamSidecarPolicy {
+		return v1alpha1.TeamSidecarPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "test-policy"},
+			Spec: v1alpha1.SidecarPolicySpec{SidecarConfig: config.SidecarConfig{
+				Container:        "test-sidecar",
+				Owner:            config.Deployment,
+				CPUAnnotationKey: "test-sidecar/cpu",
+				Ladder:           ladder,
+				Guardrails:       config.Guardrails{AllowOverrides: true},
+			}},
+		}
+	}
+
+	// a referenced ladder is held to the guardrail like steps written out in the policy
+	result := teamPolicySidecar(base, guardrails, policy("big"))
+	assert.Equal(t, []string{"sidecar guardrail test-guardrail: step big-2: cpu_request 8 is above the guardrail of 2"}, result.errs)
+
+	// and so is a ladder an owner picks with das/ladder
+	result = teamPolicySidecar(base, guardrails, policy("small"))
+	assert.Empty(t, result.errs)
+	_, err := base.WithWorkloadLadder(result.sidecarConfig, "big")
+	assert.ErrorContains(t, err, "step big-2: cpu_request 8 is above the guardrail of 2")
+}
+
+// TestPolicyStatusProfileOnly writes the status of policies that leave the owner to their profile through a fake
+// api server, which round trips them through json like the real one.
+func TestPolicyStatusProfileOnly(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, v1alpha1.AddToScheme(scheme))
+	spec := v1alpha1.SidecarPolicySpec{SidecarConfig: config.SidecarConfig{Profile: config.ProfileIstio}}
+	policy := &v1alpha1.SidecarPolicy{ObjectMeta: metav1.ObjectMeta{Name: "istio-proxy"}, Spec: spec}
+	teamPolicy := &v1alpha1.TeamSidecarPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "istio-proxy"}, Spec: spec}
+	guardrail := &v1alpha1.SidecarGuardrail{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-proxy"},
+		Spec:       v1alpha1.SidecarGuardrailSpec{Container: "istio-proxy", ClusterGuardrail: config.ClusterGuardrail{MaxCPU: "4"}},
+	}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(policy, teamPolicy, guardrail).
+		WithStatusSubresource(policy, teamPolicy).
+		Build()
+	store := config.NewStore(config.Config{})
+	ctx := context.Background()
+
+	_, err := (&SidecarPolicyReconciler{Client: c, store: store}).Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "istio-proxy"}})
+	assert.NoError(t, err)
+	var updated v1alpha1.SidecarPolicy
+	assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: "istio-proxy"}, &updated))
+	assert.True(t, updated.Status.Active)
+	assert.Empty(t, updated.Spec.Owner)
+
+	_, err = (&TeamSidecarPolicyReconciler{Client: c, store: store}).Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "istio-proxy"}})
+	assert.NoError(t, err)
+	var updatedTeam v1alpha1.TeamSidecarPolicy
+	assert.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "team-a", Name: "istio-proxy"}, &updatedTeam))
+	assert.True(t, updatedTeam.Status.Active)
+	assert.Empty(t, updatedTeam.Status.Errors)
+}
+
+func TestMatchedWorkloads(t *testing.T) {
+	controller := true
+	pod := func(name string, owner string, containers ...string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "test",
+			Name:            name,
+			OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Name: owner, Controller: &controller}},
+		}}
+		for _, container := range containers {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container})
+		}
+		return pod
+	}
+	c := fake.NewClientBuilder().
+		WithObjects(
+			pod("pod-1", "owner-a", "app", "istio-proxy"),
+			pod("pod-2", "owner-a", "app", "istio-proxy"),
+			pod("pod-3", "owner-b", "app", "log-shipper"),
+			pod("pod-4", "owner-c", "app"),
+		).
+		WithIndex(&corev1.Pod{}, podContainerField, podContainerNames).
+		Build()
+	store := config.NewStore(config.Config{Sidecars: map[string]config.SidecarConfig{
+		"istio-proxy": {Owner: config.DaemonSet},
+		"logs":        {Owner: config.DaemonSet, Container: "log-*"},
+		"vault-agent": {Owner: config.DaemonSet},
+	}})
+	r := &SidecarPolicyReconciler{Client: c, store: store}
+
+	for key, expected := range map[string]int{"istio-proxy": 1, "logs": 1, "vault-agent": 0, "missing": 0} {
+		matched, err := r.matchedWorkloads(context.Background(), key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, matched, key)
+	}
+}
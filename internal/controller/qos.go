@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkQoS holds step changes that would move the pod out of the QoS class the sidecar's qos policy asks for.
+func (r *PodReconciler) checkQoS(target ownerTarget, res *newAnnotations) error {
+	if target.pod == nil {
+		return nil
+	}
+	current := target.pod.Status.QOSClass
+	if current == "" {
+		current = qosClass(target.pod.Spec)
+	}
+	for i := range res.decisions {
+		d := res.decisions[i]
+		var want corev1.PodQOSClass
+		switch d.sidecarConfig.QoS {
+		case config.QoSGuaranteed:
+			want = corev1.PodQOSGuaranteed
+		case config.QoSBurstable:
+			want = corev1.PodQOSBurstable
+		case config.QoSPreserve:
+			want = current
+		default:
+			continue
+		}
+		fits := func(step config.ResourceStep) bool {
+			return qosClass(withStep(target.pod.Spec, d.container, step)) == want
+		}
+		reason := fmt.Sprintf("step %s would move the pod out of qos class %s", d.to.Name, want)
+		if err := r.constrain(target, res, i, config.ConstraintHold, reason, fits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withStep returns a copy of the pod spec with the container's resources set to the step.
+func withStep(spec corev1.PodSpec, containerName string, step config.ResourceStep) corev1.PodSpec {
+	res := *spec.DeepCopy()
+	resources := stepResources(step)
+	found := false
+	for i := range res.Containers {
+		if res.Containers[i].Name == containerName {
+			res.Containers[i].Resources = resources
+			found = true
+		}
+	}
+	if !found {
+		res.Containers = append(res.Containers, corev1.Container{Name: containerName, Resources: resources})
+	}
+	return res
+}
+
+// qosClass works out the QoS class of a pod spec the way the kubelet does for cpu and memory.
+// requests that are not set default to limits, as the api server does on admission.
+func qosClass(spec corev1.PodSpec) corev1.PodQOSClass {
+	var containers []corev1.Container
+	containers = append(containers, spec.InitContainers...)
+	containers = append(containers, spec.Containers...)
+
+	anySet := false
+	guaranteed := true
+	for _, container := range containers {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			request, hasRequest := container.Resources.Requests[name]
+			limit, hasLimit := container.Resources.Limits[name]
+			if hasRequest && !request.IsZero() || hasLimit && !limit.IsZero() {
+				anySet = true
+			}
+			if !hasLimit || limit.IsZero() {
+				guaranteed = false
+				continue
+			}
+			if hasRequest && request.Cmp(limit) != 0 {
+				guaranteed = false
+			}
+		}
+	}
+	switch {
+	case !anySet:
+		return corev1.PodQOSBestEffort
+	case guaranteed:
+		return corev1.PodQOSGuaranteed
+	default:
+		return corev1.PodQOSBurstable
+	}
+}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestQoSClass(t *testing.T) {
+	guaranteedApp := corev1.Container{
+		Name: "app",
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+		},
+	}
+	spec := corev1.PodSpec{Containers: []corev1.Container{guaranteedApp, {Name: "test-container"}}}
+	testcases := []struct {
+		name     string
+		spec     corev1.PodSpec
+		expected corev1.PodQOSClass
+	}{
+		{
+			name:     "best effort without any resources",
+			spec:     corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			expected: corev1.PodQOSBestEffort,
+		},
+		{
+			name:     "guaranteed when the sidecar step has requests equal to limits",
+			spec:     withStep(spec, "test-container", config.ResourceStep{CPURequest: "2", CPULimit: "2", MemRequest: "2Gi", MemLimit: "2Gi"}),
+			expected: corev1.PodQOSGuaranteed,
+		},
+		{
+			name:     "guaranteed when requests are left to default to limits",
+			spec:     withStep(spec, "test-container", config.ResourceStep{CPULimit: "2", MemLimit: "2Gi"}),
+			expected: corev1.PodQOSGuaranteed,
+		},
+		{
+			name:     "burstable when the sidecar step has requests below limits",
+			spec:     withStep(spec, "test-container", config.ResourceStep{CPURequest: "1", CPULimit: "2", MemRequest: "2Gi", MemLimit: "2Gi"}),
+			expected: corev1.PodQOSBurstable,
+		},
+		{
+			name:     "burstable when a container has no limits",
+			spec:     spec,
+			expected: corev1.PodQOSBurstable,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			assert.Equal(t, testcase.expected, qosClass(testcase.spec))
+		})
+	}
+}
+
+// TestSettleQoS puts a step change of a sidecar in a guaranteed pod through settle with each qos policy.
+func TestSettleQoS(t *testing.T) {
+	resources := func(cpu string, memory string) corev1.ResourceRequirements {
+		list := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)}
+		return corev1.ResourceRequirements{Requests: list, Limits: list}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-pod"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Resources: resources("1", "1Gi")},
+			{Name: "test-container", Resources: resources("100m", "128Mi")},
+		}},
+		Status: corev1.PodStatus{QOSClass: corev1.PodQOSGuaranteed},
+	}
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-quota"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")},
+			Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")},
+		},
+	}
+	withLimits := func(sidecarConfig *config.SidecarConfig) {
+		sidecarConfig.CPULimitAnnotationKey = "test-container/cpu-limit"
+		sidecarConfig.MemLimitAnnotationKey = "test-container/mem-limit"
+		for i := range sidecarConfig.Steps {
+			sidecarConfig.Steps[i].CPULimit = sidecarConfig.Steps[i].CPURequest
+			sidecarConfig.Steps[i].MemLimit = sidecarConfig.Steps[i].MemRequest
+		}
+	}
+	held := "Warning StepHeld held test-container on step test-step-1 instead of moving to test-step-2: step test-step-2 would move the pod out of qos class Guaranteed"
+	testcases := []struct {
+		name     string
+		qos      config.QoSPolicy
+		limits   bool
+		objects  []client.Object
+		expected dasDetail
+		cpu      any
+		events   []string
+	}{
+		{
+			name:     "hold a step without limits that would make the pod burstable",
+			qos:      config.QoSPreserve,
+			expected: dasDetail{Name: "test-step-1", RestartCount: 2},
+			events:   []string{held},
+		},
+		{
+			name:   "step up when the step keeps the pod guaranteed",
+			qos:    config.QoSGuaranteed,
+			limits: true,
+			expected: dasDetail{
+				Name: "test-step-2",
+				Keys: []string{"test-container/cpu", "test-container/cpu-limit", "test-container/mem", "test-container/mem-limit"},
+			},
+			cpu:    "200m",
+			events: []string{"Normal StepChanged moved test-container from step test-step-1 to test-step-2, estimated monthly cost delta 0.00"},
+		},
+		{
+			name:     "leave a step held for qos to the checks after it",
+			qos:      config.QoSPreserve,
+			objects:  []client.Object{quota},
+			expected: dasDetail{Name: "test-step-1", RestartCount: 2},
+			events:   []string{held},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sidecarConfig := testSidecar(func(sidecarConfig *config.SidecarConfig) {
+				sidecarConfig.QoS = testcase.qos
+				if testcase.limits {
+					withLimits(sidecarConfig)
+				}
+			})
+			res := settleRestart(t, settleCase{
+				conf:    config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}},
+				pod:     pod,
+				objects: testcase.objects,
+			})
+			assert.Equal(t, map[string]dasDetail{"test-container": testcase.expected}, res.details)
+			assert.Equal(t, testcase.cpu, res.podAnnotations["test-container/cpu"])
+			assert.Equal(t, testcase.events, res.events)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// checkQuota compares the extra requests and limits each step change needs across all pods of the owner
+// with what is left in the namespace's resource quotas, so that a rollout is not half rejected by quota admission.
+// quota scopes are not looked at, so every quota in the namespace is treated as applying to the owner's pods.
+func (r *PodReconciler) checkQuota(ctx context.Context, target ownerTarget, res *newAnnotations) error {
+	if !needsCheck(res.decisions, func(sidecarConfig config.SidecarConfig) config.ConstraintPolicy { return sidecarConfig.QuotaPolicy }) {
+		return nil
+	}
+	var quotas corev1.ResourceQuotaList
+	if err := r.List(ctx, &quotas, client.InNamespace(target.namespacedName.Namespace)); err != nil {
+		return fmt.Errorf("error listing resource quotas in %s: %w", target.namespacedName.Namespace, err)
+	}
+	remaining := remainingQuota(quotas.Items)
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	for i := range res.decisions {
+		d := res.decisions[i]
+		fits := func(step config.ResourceStep) bool {
+			return fitsQuota(remaining, stepDelta(d.from, step, target.replicas))
+		}
+		reason := fmt.Sprintf("resource quota in %s cannot take step %s across %d pods", target.namespacedName.Namespace, d.to.Name, target.replicas)
+		if err := r.constrain(target, res, i, d.sidecarConfig.QuotaPolicy, reason, fits); err != nil {
+			return err
+		}
+		if res.decisions[i].held {
+			continue
+		}
+		for name, q := range stepDelta(d.from, res.decisions[i].to, target.replicas) {
+			if rem, ok := remaining[name]; ok {
+				rem.Sub(q)
+				remaining[name] = rem
+			}
+		}
+	}
+	return nil
+}
+
+func needsCheck(decisions []decision, policy func(sidecarConfig config.SidecarConfig) config.ConstraintPolicy) bool {
+	for _, d := range decisions {
+		if !d.held && policy(d.sidecarConfig) != config.ConstraintIgnore {
+			return true
+		}
+	}
+	return false
+}
+
+// remainingQuota is the smallest hard minus used across quotas for each resource das changes.
+// the short forms cpu and memory in a quota are the same as requests.cpu and requests.memory.
+func remainingQuota(quotas []corev1.ResourceQuota) corev1.ResourceList {
+	res := make(corev1.ResourceList)
+	for _, quota := range quotas {
+		for name, hard := range quota.Status.Hard {
+			key := name
+			switch name {
+			case corev1.ResourceCPU:
+				key = corev1.ResourceRequestsCPU
+			case corev1.ResourceMemory:
+				key = corev1.ResourceRequestsMemory
+			case corev1.ResourceRequestsCPU, corev1.ResourceRequestsMemory, corev1.ResourceLimitsCPU, corev1.ResourceLimitsMemory:
+			default:
+				continue
+			}
+			rem := hard.DeepCopy()
+			if used, ok := quota.Status.Used[name]; ok {
+				rem.Sub(used)
+			}
+			if current, ok := res[key]; !ok || rem.Cmp(current) < 0 {
+				res[key] = rem
+			}
+		}
+	}
+	return res
+}
+
+func fitsQuota(remaining corev1.ResourceList, delta corev1.ResourceList) bool {
+	for name, q := range delta {
+		rem, ok := remaining[name]
+		if !ok {
+			continue
+		}
+		if q.Cmp(rem) > 0 {
+			slog.Debug("step change does not fit quota", "resource", name, "needed", q.String(), "remaining", rem.String())
+			return false
+		}
+	}
+	return true
+}
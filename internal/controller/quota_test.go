@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRemainingQuota(t *testing.T) {
+	testcases := []struct {
+		name     string
+		quotas   []corev1.ResourceQuota
+		expected corev1.ResourceList
+	}{
+		{
+			name:     "return empty list without quotas",
+			expected: corev1.ResourceList{},
+		},
+		{
+			name: "normalise short forms and skip resources das does not change",
+			quotas: []corev1.ResourceQuota{
+				{
+					Status: corev1.ResourceQuotaStatus{
+						Hard: corev1.ResourceList{
+							corev1.ResourceCPU:          resource.MustParse("10"),
+							corev1.ResourceLimitsMemory: resource.MustParse("20Gi"),
+							corev1.ResourcePods:         resource.MustParse("10"),
+						},
+						Used: corev1.ResourceList{
+							corev1.ResourceCPU:          resource.MustParse("4"),
+							corev1.ResourceLimitsMemory: resource.MustParse("5Gi"),
+						},
+					},
+				},
+			},
+			expected: corev1.ResourceList{
+				corev1.ResourceRequestsCPU:  resource.MustParse("6"),
+				corev1.ResourceLimitsMemory: resource.MustParse("15Gi"),
+			},
+		},
+		{
+			name: "take the smallest remaining across quotas",
+			quotas: []corev1.ResourceQuota{
+				{
+					Status: corev1.ResourceQuotaStatus{
+						Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("10")},
+						Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2")},
+					},
+				},
+				{
+					Status: corev1.ResourceQuotaStatus{
+						Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("5")},
+						Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2")},
+					},
+				},
+			},
+			expected: corev1.ResourceList{
+				corev1.ResourceRequestsCPU: resource.MustParse("3"),
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res := remainingQuota(testcase.quotas)
+			assert.Equal(t, len(testcase.expected), len(res))
+			for name, q := range testcase.expected {
+				actual := res[name]
+				assert.Equal(t, 0, q.Cmp(actual), "resource %s: expected %s, got %s", name, q.String(), actual.String())
+			}
+		})
+	}
+}
+
+func TestFitsQuota(t *testing.T) {
+	from := config.ResourceStep{CPURequest: "1", MemRequest: "1Gi", CPULimit: "1", MemLimit: "1Gi"}
+	to := config.ResourceStep{CPURequest: "2", MemRequest: "2Gi", CPULimit: "2", MemLimit: "2Gi"}
+	testcases := []struct {
+		name      string
+		remaining corev1.ResourceList
+		replicas  int32
+		expected  bool
+	}{
+		{
+			name:      "fits when remaining covers the delta across replicas",
+			remaining: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("3")},
+			replicas:  3,
+			expected:  true,
+		},
+		{
+			name:      "does not fit when one resource is short",
+			remaining: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("3"), corev1.ResourceLimitsMemory: resource.MustParse("2Gi")},
+			replicas:  3,
+		},
+		{
+			name:     "fits when no quota covers the resources",
+			replicas: 3,
+			expected: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			res := fitsQuota(testcase.remaining, stepDelta(from, to, testcase.replicas))
+			assert.Equal(t, testcase.expected, res)
+		})
+	}
+}
+
+// TestSettleQuota puts a step change through settle against a namespace quota with each quota policy.
+func TestSettleQuota(t *testing.T) {
+	quota := func(hard string, used string) *corev1.ResourceQuota {
+		return &corev1.ResourceQuota{
+			ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-quota"},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse(hard)},
+				Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse(used)},
+			},
+		}
+	}
+	reason := func(step string) string {
+		return "resource quota in test cannot take step " + step + " across 2 pods"
+	}
+	testcases := []struct {
+		name           string
+		policy         config.ConstraintPolicy
+		quota          *corev1.ResourceQuota
+		recommendation corev1.ResourceList
+		expected       dasDetail
+		cpu            any
+		events         []string
+	}{
+		{
+			name:     "step up when the quota has room",
+			policy:   config.ConstraintHold,
+			quota:    quota("2", "1"),
+			expected: dasDetail{Name: "test-step-2", Keys: []string{"test-container/cpu", "test-container/mem"}},
+			cpu:      "200m",
+			events:   []string{"Normal StepChanged moved test-container from step test-step-1 to test-step-2, estimated monthly cost delta 0.00"},
+		},
+		{
+			name:     "hold when the quota has no room",
+			policy:   config.ConstraintHold,
+			quota:    quota("2", "1900m"),
+			expected: dasDetail{Name: "test-step-1", RestartCount: 2},
+			events:   []string{"Warning StepHeld held test-container on step test-step-1 instead of moving to test-step-2: " + reason("test-step-2")},
+		},
+		{
+			name:           "cap to the highest step the quota has room for",
+			policy:         config.ConstraintCap,
+			quota:          quota("2", "1300m"),
+			recommendation: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("800m")},
+			expected: dasDetail{
+				Name:       "test-step-3",
+				Adjustment: "capped from test-step-4: " + reason("test-step-4"),
+				Keys:       []string{"test-container/cpu", "test-container/mem"},
+			},
+			cpu: "400m",
+			events: []string{
+				"Warning StepCapped capped test-container from step test-step-4 to test-step-3: " + reason("test-step-4"),
+				"Normal StepChanged moved test-container from step test-step-1 to test-step-3, estimated monthly cost delta 0.00",
+			},
+		},
+		{
+			name:     "step up over the quota when ignored",
+			policy:   config.ConstraintIgnore,
+			quota:    quota("2", "1900m"),
+			expected: dasDetail{Name: "test-step-2", Keys: []string{"test-container/cpu", "test-container/mem"}},
+			cpu:      "200m",
+			events:   []string{"Normal StepChanged moved test-container from step test-step-1 to test-step-2, estimated monthly cost delta 0.00"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sidecarConfig := testSidecar(func(sidecarConfig *config.SidecarConfig) { sidecarConfig.QuotaPolicy = testcase.policy })
+			res := settleRestart(t, settleCase{
+				conf:           config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}},
+				replicas:       2,
+				recommendation: testcase.recommendation,
+				objects:        []client.Object{testcase.quota},
+			})
+			assert.Equal(t, map[string]dasDetail{"test-container": testcase.expected}, res.details)
+			assert.Equal(t, testcase.cpu, res.podAnnotations["test-container/cpu"])
+			assert.Equal(t, testcase.events, res.events)
+		})
+	}
+}
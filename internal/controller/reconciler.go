@@ -10,7 +10,9 @@ import (
 	"github.com/bento01dev/das/internal/config"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -21,6 +23,14 @@ const labelName string = "app.kubernetes.io/name"
 type containerDetail struct {
 	sidecarConfig   config.SidecarConfig
 	containerStatus corev1.ContainerStatus
+	// recommendation is the VPA target for the container, if das was asked to use it
+	recommendation corev1.ResourceList
+	// nodeClass is the node pool ladder picked for the container, empty when the sidecar's own steps are used
+	nodeClass string
+	// image is the container's image in the pod spec, which is the owner template's image after any injection
+	image string
+	// resources are the container's resources in the pod spec
+	resources corev1.ResourceRequirements
 }
 
 type podOwnerDetail struct {
@@ -31,40 +41,74 @@ type podOwnerDetail struct {
 type dasDetail struct {
 	Name         string `json:"name"`
 	RestartCount int    `json:"restart_count"`
+	// Adjustment describes how the applied step differs from the configured step, like a limit range clamp
+	Adjustment string `json:"adjustment,omitempty"`
+	// Floor is the step of a schedule floor currently applied above the learnt step in Name
+	Floor string `json:"floor,omitempty"`
+	// Image is the container image when das last set the step
+	Image string `json:"image,omitempty"`
+	// Keys are the annotation keys das has written for the container on the pod template, and OwnerKeys the ones
+	// it has written on the owner. only these are claimed in an apply, at the level das wrote them, so a value set
+	// by someone else under a configured key is never taken over.
+	Keys      []string `json:"keys,omitempty"`
+	OwnerKeys []string `json:"owner_keys,omitempty"`
+}
+
+// ownerTarget is the owner das is about to update, along with what the checks before an update need to know about it.
+type ownerTarget struct {
+	kind           config.Owner
+	namespacedName types.NamespacedName
+	object         client.Object
+	// replicas is the number of pods the owner runs. for a daemon set, this is the number of nodes it is scheduled on.
+	replicas    int32
+	annotations map[string]string
+	podTemplate corev1.PodTemplateSpec
+	// pod is the pod whose restart started the update
+	pod *corev1.Pod
+	// selector picks out the owner's pods
+	selector *metav1.LabelSelector
 }
 
 type updateResult struct {
 	appName string
-	steps   map[string]config.ResourceStep
+	steps   map[string]blob.StepRecord
 }
 
 type modifier interface {
 	getOwnerDetails(pod *corev1.Pod) map[config.Owner]types.NamespacedName
 	getCurrentStep(sidecarConfig config.SidecarConfig, stepName string) config.ResourceStep
 	getNextStep(sidecarConfig config.SidecarConfig, currentStep string) int
-	matchDetails(pod *corev1.Pod) []containerDetail
+	matchDetails(pod *corev1.Pod, namespaceLabels map[string]string) []containerDetail
+	selectLadders(details []containerDetail, pod *corev1.Pod, nodeLabels map[string]string) []containerDetail
 	filterTerminated(details []containerDetail) []containerDetail
 	groupByOwner(details []containerDetail) map[config.Owner][]containerDetail
 	newAnnotations(details []containerDetail, currentOwnerAnnotations map[string]string, currentPodAnnotations map[string]string) (newAnnotations, error)
+	ownedAnnotations(ownerAnnotations map[string]string, podAnnotations map[string]string) (map[string]string, map[string]string)
+	sweepSteps(workload config.Workload, currentOwnerAnnotations map[string]string, currentPodAnnotations map[string]string, images map[string]string) (newAnnotations, error)
+	holdStep(res *newAnnotations, i int, reason string) error
+	replaceStep(res *newAnnotations, i int, step config.ResourceStep, note string) error
+	coverStep(res *newAnnotations, i int, step config.ResourceStep, note string)
 }
 
 type storer interface {
-	UploadNewSteps(appName string, steps map[string]config.ResourceStep) (string, error)
+	UploadNewSteps(appName string, steps map[string]blob.StepRecord) (string, error)
 }
 
 type PodReconciler struct {
 	client.Client
-	conf     config.Config
+	conf     *config.Store
 	modifier modifier
 	storer   storer
+	recorder record.EventRecorder
 }
 
-func NewPodReconciler(c client.Client, conf config.Config, m modifier, s storer) *PodReconciler {
+func NewPodReconciler(c client.Client, conf *config.Store, m modifier, s storer, recorder record.EventRecorder) *PodReconciler {
 	return &PodReconciler{
 		Client:   c,
 		conf:     conf,
 		modifier: m,
 		storer:   s,
+		recorder: recorder,
 	}
 }
 
@@ -90,14 +134,14 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	}
 
 	ownerDetails := r.modifier.getOwnerDetails(pod)
-	details := r.modifier.matchDetails(pod)
+	details := r.modifier.matchDetails(pod, r.namespaceLabels(ctx, pod.Namespace))
 	details = r.modifier.filterTerminated(details)
 	if len(details) < 1 {
 		return ctrl.Result{}, nil
 	}
 	groupedDetails := r.modifier.groupByOwner(details)
 
-	updateResult, err := r.updateOwners(ctx, groupedDetails, ownerDetails)
+	updateResult, err := r.updateOwners(ctx, pod, groupedDetails, ownerDetails)
 	if err != nil {
 		slog.Error("error in updating owner", "pod_name", req.NamespacedName.Name, "namespace", req.Namespace, "err", err.Error())
 		// this error could be because of conflict in update. retry with backoff as normal
@@ -123,7 +167,7 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	return ctrl.Result{}, nil
 }
 
-func (r *PodReconciler) updateOwners(ctx context.Context, groupedDetails map[config.Owner][]containerDetail, ownerNamespacedNames map[config.Owner]types.NamespacedName) (updateResult, error) {
+func (r *PodReconciler) updateOwners(ctx context.Context, pod *corev1.Pod, groupedDetails map[config.Owner][]containerDetail, ownerNamespacedNames map[config.Owner]types.NamespacedName) (updateResult, error) {
 	// okay, yes this for loop looks a bit weird.
 	// map of owners cannot have deployment and daemon set for the same container.
 	// the reason its this way is because in the case of a deployment, a mutating webhook or manual addition of annotation can happen at a deployment, replicaset or pod level.
@@ -142,7 +186,7 @@ func (r *PodReconciler) updateOwners(ctx context.Context, groupedDetails map[con
 
 	if deploymentExists {
 		slog.Debug("calling update deployment", "details", deploymentDetails, "owner_namespaces", ownerNamespacedNames)
-		deploymentRes, err := r.updateDeployment(ctx, deploymentDetails, ownerNamespacedNames)
+		deploymentRes, err := r.updateDeployment(ctx, pod, deploymentDetails, ownerNamespacedNames)
 		if err != nil {
 			return updateResult{}, err
 		}
@@ -151,7 +195,7 @@ func (r *PodReconciler) updateOwners(ctx context.Context, groupedDetails map[con
 
 	if daemonsetExists {
 		slog.Debug("calling update daemon set", "details", daemonSetDetails, "owner_namespaces", ownerNamespacedNames)
-		daemonsetRes, err := r.updateDaemonSet(ctx, daemonSetDetails, ownerNamespacedNames)
+		daemonsetRes, err := r.updateDaemonSet(ctx, pod, daemonSetDetails, ownerNamespacedNames)
 		if err != nil {
 			return updateResult{}, err
 		}
@@ -160,7 +204,7 @@ func (r *PodReconciler) updateOwners(ctx context.Context, groupedDetails map[con
 	return updateResult{}, nil
 }
 
-func (r *PodReconciler) updateDeployment(ctx context.Context, details []containerDetail, ownerNamespacedNames map[config.Owner]types.NamespacedName) (updateResult, error) {
+func (r *PodReconciler) updateDeployment(ctx context.Context, pod *corev1.Pod, details []containerDetail, ownerNamespacedNames map[config.Owner]types.NamespacedName) (updateResult, error) {
 	var err error
 	var res updateResult
 
@@ -191,35 +235,26 @@ func (r *PodReconciler) updateDeployment(ctx context.Context, details []containe
 		return res, fmt.Errorf("failed in retrieving deployment as owner of pod: %w", err)
 	}
 
-	l := labelName
-	if r.conf.LabelName != "" {
-		l = r.conf.LabelName
-	}
-	appName := deployment.Labels[l]
-
-	currentOwnerAnnotations := deployment.ObjectMeta.Annotations
-	currentPodAnnotations := deployment.Spec.Template.Annotations
+	appName := r.appName(deployment.Labels)
 
-	newAnnotations, err := r.modifier.newAnnotations(details, currentOwnerAnnotations, currentPodAnnotations)
-	if err != nil {
-		slog.Error("failed in generating new annotations for deployment", "err", err.Error(), "current_owner_annotations", currentOwnerAnnotations, "current_pod_annotations", currentPodAnnotations)
-		return res, fmt.Errorf("failed in updating annotations for %s in %s: %w", deployment.Name, deployment.Namespace, err)
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
 	}
-	deployment.ObjectMeta.Annotations = newAnnotations.ownerAnnotations
-	deployment.Spec.Template.Annotations = newAnnotations.podAnnotations
-
-	err = r.Update(ctx, &deployment)
-	if err != nil {
-		slog.Error("failed in updating deployment", "err", err.Error(), "owner_name", deploymentNamespacedName.Name, "owner_namespace", deploymentNamespacedName.Namespace)
-		return res, fmt.Errorf("failed updating deployment with the new annotations for %s: %w", deployment.Name, err)
+	target := ownerTarget{
+		kind:           config.Deployment,
+		namespacedName: deploymentNamespacedName,
+		object:         &deployment,
+		replicas:       replicas,
+		annotations:    deployment.ObjectMeta.Annotations,
+		podTemplate:    deployment.Spec.Template,
+		pod:            pod,
+		selector:       deployment.Spec.Selector,
 	}
-
-	res = updateResult{appName: appName, steps: newAnnotations.steps}
-
-	return res, nil
+	return r.commit(ctx, target, appName, details)
 }
 
-func (r *PodReconciler) updateDaemonSet(ctx context.Context, details []containerDetail, ownerNamespacedNames map[config.Owner]types.NamespacedName) (updateResult, error) {
+func (r *PodReconciler) updateDaemonSet(ctx context.Context, pod *corev1.Pod, details []containerDetail, ownerNamespacedNames map[config.Owner]types.NamespacedName) (updateResult, error) {
 	var err error
 	var res updateResult
 
@@ -234,28 +269,107 @@ func (r *PodReconciler) updateDaemonSet(ctx context.Context, details []container
 		slog.Error("error retrieving replica set", "err", err.Error(), "owner_name", daemonSetNamespacedName.Name, "owner_namespace", daemonSetNamespacedName.Namespace)
 		return res, fmt.Errorf("error in retrieving daemon set details for %v: %w", daemonSetNamespacedName, err)
 	}
-	labelName := "app.kubernetes.io/name"
-	if r.conf.LabelName != "" {
-		labelName = r.conf.LabelName
+	appName := r.appName(daemonSet.Labels)
+	target := ownerTarget{
+		kind:           config.DaemonSet,
+		namespacedName: daemonSetNamespacedName,
+		object:         &daemonSet,
+		replicas:       daemonSet.Status.DesiredNumberScheduled,
+		annotations:    daemonSet.ObjectMeta.Annotations,
+		podTemplate:    daemonSet.Spec.Template,
+		pod:            pod,
+		selector:       daemonSet.Spec.Selector,
+	}
+	return r.commit(ctx, target, appName, details)
+}
+
+// appName is the name the owner's steps are stored under, read from its labels.
+func (r *PodReconciler) appName(labels map[string]string) string {
+	l := labelName
+	if conf := r.conf.Load(); conf.LabelName != "" {
+		l = conf.LabelName
 	}
-	appName := daemonSet.Labels[labelName]
-	currentOwnerAnnotations := daemonSet.ObjectMeta.Annotations
-	currentPodAnnotations := daemonSet.Spec.Template.Annotations
+	return labels[l]
+}
+
+// commit works out the new annotations for the owner from the restarts of its containers and settles them.
+func (r *PodReconciler) commit(ctx context.Context, target ownerTarget, appName string, details []containerDetail) (updateResult, error) {
+	var res updateResult
+
+	currentOwnerAnnotations := target.annotations
+	currentPodAnnotations := target.podTemplate.Annotations
+	details = r.withNodePoolLadders(ctx, target, details)
+	details = withTemplateImages(target, details)
+	details = r.withWorkloadLadder(target, details)
+	details = r.withOverrides(target, details)
+	details = r.withVPARecommendations(ctx, target.kind, target.namespacedName, details)
 	newAnnotations, err := r.modifier.newAnnotations(details, currentOwnerAnnotations, currentPodAnnotations)
 	if err != nil {
-		slog.Error("error in generating new annotations for daemonset", "err", err.Error(), "current_owner_annotations", currentOwnerAnnotations, "current_pod_annotations", currentPodAnnotations)
-		return res, fmt.Errorf("error in updating annotations for %s in %s: %w", daemonSet.Name, daemonSet.Namespace, err)
+		slog.Error("error in generating new annotations for owner", "err", err.Error(), "owner_kind", target.kind, "current_owner_annotations", currentOwnerAnnotations, "current_pod_annotations", currentPodAnnotations)
+		return res, fmt.Errorf("error in updating annotations for %s in %s: %w", target.namespacedName.Name, target.namespacedName.Namespace, err)
 	}
-	daemonSet.ObjectMeta.Annotations = newAnnotations.ownerAnnotations
-	daemonSet.Spec.Template.Annotations = newAnnotations.podAnnotations
+	return r.settle(ctx, target, appName, &newAnnotations)
+}
 
-	err = r.Update(ctx, &daemonSet)
+// settle checks the step changes in the new annotations against whatever could block them, estimates their cost
+// and applies them unless das is frozen. step changes from restarts and from schedule floors both go through here.
+func (r *PodReconciler) settle(ctx context.Context, target ownerTarget, appName string, newAnnotations *newAnnotations) (updateResult, error) {
+	var res updateResult
+
+	err := r.clampToLimitRanges(ctx, target, newAnnotations)
+	if err != nil {
+		return res, err
+	}
+	err = r.checkQoS(target, newAnnotations)
+	if err != nil {
+		return res, err
+	}
+	err = r.checkQuota(ctx, target, newAnnotations)
+	if err != nil {
+		return res, err
+	}
+	err = r.checkNodeCapacity(ctx, target, newAnnotations)
+	if err != nil {
+		return res, err
+	}
+	err = r.checkBudget(ctx, target, newAnnotations)
 	if err != nil {
-		slog.Error("error in updating daemon set", "err", err.Error(), "owner_name", daemonSetNamespacedName.Name, "owner_namespace", daemonSetNamespacedName.Namespace)
-		return res, fmt.Errorf("error updating deployment with the new annotations for %s: %w", daemonSet.Name, err)
+		return res, err
+	}
+	r.coverNodeClasses(ctx, target, newAnnotations)
+	for i, d := range newAnnotations.decisions {
+		newAnnotations.decisions[i].monthlyCostDelta = monthlyCostDelta(r.conf.Load().Pricing, d.from, d.to, target.replicas)
+	}
+
+	frozen, err := r.frozen(ctx)
+	if err != nil {
+		return res, err
+	}
+	if frozen {
+		slog.Warn("das is frozen. skipping update", "owner_kind", target.kind, "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace)
+		logDecisions(target.kind, target.namespacedName, newAnnotations.decisions, true, "frozen")
+		return res, nil
 	}
 
-	res = updateResult{appName: appName, steps: newAnnotations.steps}
+	ownerAnnotations, podAnnotations := r.modifier.ownedAnnotations(newAnnotations.ownerAnnotations, newAnnotations.podAnnotations)
+	err = r.applyAnnotations(ctx, target.kind, target.namespacedName, target.object.GetResourceVersion(), ownerAnnotations, podAnnotations)
+	if err != nil {
+		slog.Error("error in updating owner", "err", err.Error(), "owner_kind", target.kind, "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace)
+		return res, fmt.Errorf("error updating %s with the new annotations for %s: %w", target.kind, target.namespacedName.Name, err)
+	}
+
+	r.reconcileHPAs(ctx, target.kind, target.namespacedName, newAnnotations.decisions)
+	r.publishVPASteps(ctx, target.kind, target.namespacedName, newAnnotations.decisions)
+	logDecisions(target.kind, target.namespacedName, newAnnotations.decisions, false, "")
+	records := make(map[string]blob.StepRecord)
+	for _, d := range newAnnotations.decisions {
+		if d.held {
+			continue
+		}
+		r.normalEvent(target, "StepChanged", "moved %s from step %s to %s, estimated monthly cost delta %.2f", d.container, d.from.Name, d.to.Name, d.monthlyCostDelta)
+		records[detailKey(d.container, d.nodeClass)] = blob.StepRecord{ResourceStep: d.to, MonthlyCostDelta: d.monthlyCostDelta}
+	}
+	res = updateResult{appName: appName, steps: records}
 
 	return res, nil
 }
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bento01dev/das/internal/blob"
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// testSidecar is a sidecar stepping up after every restart, with the step requests doubling each time.
+func testSidecar(modify func(sidecarConfig *config.SidecarConfig)) config.SidecarConfig {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		ErrCodes:         []int{137},
+		CPUAnnotationKey: "test-container/cpu",
+		MemAnnotationKey: "test-container/mem",
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", RestartLimit: 1, CPURequest: "100m", MemRequest: "128Mi"},
+			{Name: "test-step-2", RestartLimit: 1, CPURequest: "200m", MemRequest: "256Mi"},
+			{Name: "test-step-3", RestartLimit: 1, CPURequest: "400m", MemRequest: "512Mi"},
+			{Name: "test-step-4", RestartLimit: 1, CPURequest: "800m", MemRequest: "1Gi"},
+		},
+	}
+	if modify != nil {
+		modify(&sidecarConfig)
+	}
+	return sidecarConfig
+}
+
+// settleCase is a deployment with test-container on test-step-1 restarting once more, and what is around it in the cluster.
+type settleCase struct {
+	conf config.Config
+	// step is the step test-container is on, if not test-step-1.
+	step        string
+	annotations map[string]string
+	replicas    int32
+	// pod is the pod whose restart started the update. its spec is used as the deployment's pod template.
+	pod *corev1.Pod
+	// recommendation makes das skip ahead to the step covering it, so that a check has steps to cap to.
+	recommendation corev1.ResourceList
+	objects        []client.Object
+}
+
+// settled is what das applied to the deployment and the events it recorded on it.
+type settled struct {
+	steps          map[string]blob.StepRecord
+	details        map[string]dasDetail
+	podAnnotations map[string]any
+	events         []string
+}
+
+// settleRestart runs the restart of test-container through commit, and so through every check in settle.
+func settleRestart(t *testing.T, testcase settleCase) settled {
+	t.Helper()
+	step := testcase.step
+	if step == "" {
+		step = "test-step-1"
+	}
+	annotations := map[string]string{dasDetailsKey: `{"test-container":{"name":"` + step + `","restart_count":1}}`}
+	for key, value := range testcase.annotations {
+		annotations[key] = value
+	}
+	replicas := testcase.replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-deployment", Annotations: annotations},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	if testcase.pod != nil {
+		deployment.Spec.Template.Spec = testcase.pod.Spec
+	}
+
+	var sent map[string]any
+	c := fake.NewClientBuilder().
+		WithObjects(append(testcase.objects, deployment)...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if _, ok := obj.(*appsv1.Deployment); !ok {
+					return nil
+				}
+				data, err := patch.Data(obj)
+				if err != nil {
+					return err
+				}
+				return json.Unmarshal(data, &sent)
+			},
+		}).
+		Build()
+	store := config.NewStore(testcase.conf)
+	recorder := record.NewFakeRecorder(10)
+	r := NewPodReconciler(c, store, NewPodOwnerModifier(store), nil, recorder)
+	target := ownerTarget{
+		kind:           config.Deployment,
+		namespacedName: types.NamespacedName{Namespace: "test", Name: "test-deployment"},
+		object:         deployment,
+		replicas:       replicas,
+		annotations:    deployment.Annotations,
+		podTemplate:    deployment.Spec.Template,
+		pod:            testcase.pod,
+	}
+	details := []containerDetail{{
+		sidecarConfig:   testcase.conf.Sidecars["test-container"],
+		containerStatus: corev1.ContainerStatus{Name: "test-container"},
+		recommendation:  testcase.recommendation,
+	}}
+
+	res, err := r.commit(context.Background(), target, "test-app", details)
+	assert.NoError(t, err)
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	metadata := sent["metadata"].(map[string]any)
+	var dasDetails map[string]dasDetail
+	assert.NoError(t, json.Unmarshal([]byte(metadata["annotations"].(map[string]any)[dasDetailsKey].(string)), &dasDetails))
+	podAnnotations, _, _ := unstructured.NestedMap(sent, "spec", "template", "metadata", "annotations")
+	return settled{steps: res.steps, details: dasDetails, podAnnotations: podAnnotations, events: events}
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bento01dev/das/internal/config"
+)
+
+// configReloader watches the config file and swaps a new config into the store shared by the reconciler and the modifier.
+// it runs on every replica, not just the leader, so a replica taking over the lease already has the latest config.
+type configReloader struct {
+	configFilePath string
+	store          *config.Store
+}
+
+func (c configReloader) Start(ctx context.Context) error {
+	configLastReload.SetToCurrentTime()
+	return config.Watch(ctx, c.configFilePath, c.store, c.report)
+}
+
+func (c configReloader) NeedLeaderElection() bool {
+	return false
+}
+
+func (c configReloader) report(err error) {
+	if err != nil {
+		slog.Error("error reloading config. keeping the config in use", "config_path", c.configFilePath, "err", err.Error())
+		configReloads.WithLabelValues("failure").Inc()
+		return
+	}
+	slog.Info("config reloaded", "config_path", c.configFilePath, "sidecars", len(c.store.Load().Sidecars))
+	configReloads.WithLabelValues("success").Inc()
+	configLastReload.Set(float64(time.Now().Unix()))
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// stepResources parses the quantities of a step into resource requirements.
+// empty or unparseable quantities are left out.
+func stepResources(step config.ResourceStep) corev1.ResourceRequirements {
+	res := corev1.ResourceRequirements{
+		Requests: make(corev1.ResourceList),
+		Limits:   make(corev1.ResourceList),
+	}
+	setQuantity(res.Requests, corev1.ResourceCPU, step.CPURequest)
+	setQuantity(res.Requests, corev1.ResourceMemory, step.MemRequest)
+	setQuantity(res.Limits, corev1.ResourceCPU, step.CPULimit)
+	setQuantity(res.Limits, corev1.ResourceMemory, step.MemLimit)
+	return res
+}
+
+func setQuantity(list corev1.ResourceList, name corev1.ResourceName, value string) {
+	if value == "" {
+		return
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return
+	}
+	list[name] = q
+}
+
+// coverSteps raises each request and limit of step to the one of other where other's is larger, or where step
+// leaves it unset, and reports whether anything was raised.
+func coverSteps(step config.ResourceStep, other config.ResourceStep) (config.ResourceStep, bool) {
+	raised := false
+	for _, field := range []struct {
+		value *string
+		other string
+	}{
+		{&step.CPURequest, other.CPURequest},
+		{&step.CPULimit, other.CPULimit},
+		{&step.MemRequest, other.MemRequest},
+		{&step.MemLimit, other.MemLimit},
+	} {
+		o, err := resource.ParseQuantity(field.other)
+		if err != nil {
+			continue
+		}
+		if v, err := resource.ParseQuantity(*field.value); err == nil && v.Cmp(o) >= 0 {
+			continue
+		}
+		*field.value = field.other
+		raised = true
+	}
+	return step, raised
+}
+
+// quotaResources flattens a step into the resource names used by resource quotas.
+func quotaResources(step config.ResourceStep) corev1.ResourceList {
+	resources := stepResources(step)
+	res := make(corev1.ResourceList)
+	for name, q := range resources.Requests {
+		res[corev1.ResourceName("requests."+string(name))] = q
+	}
+	for name, q := range resources.Limits {
+		res[corev1.ResourceName("limits."+string(name))] = q
+	}
+	return res
+}
+
+// stepDelta is how much more of each resource a pod needs moving from one step to another, times the number of pods.
+func stepDelta(from config.ResourceStep, to config.ResourceStep, replicas int32) corev1.ResourceList {
+	fromResources := quotaResources(from)
+	res := make(corev1.ResourceList)
+	for name, q := range quotaResources(to) {
+		delta := q.DeepCopy()
+		if f, ok := fromResources[name]; ok {
+			delta.Sub(f)
+		}
+		res[name] = multiply(delta, replicas)
+	}
+	return res
+}
+
+func multiply(q resource.Quantity, n int32) resource.Quantity {
+	return *resource.NewMilliQuantity(q.MilliValue()*int64(n), q.Format)
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/bento01dev/das/internal/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const sweepInterval = time.Minute
+
+// RunSweeps goes over the owners das tracks every minute until ctx is done. it applies schedule floors ahead of time
+// and goes back to the learnt steps after, and drops containers back to their image reset step when the owner
+// rolls out a new image. floors and image resets can be added by a config reload, so it keeps ticking even while
+// there are none.
+func (r *PodReconciler) RunSweeps(ctx context.Context) error {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !r.needsSweep() {
+				continue
+			}
+			r.sweepOwners(ctx)
+		}
+	}
+}
+
+func (r *PodReconciler) needsSweep() bool {
+	for _, sidecarConfig := range r.conf.Load().Sidecars {
+		if len(sidecarConfig.Floors) > 0 || sidecarConfig.ImageResetStep != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PodReconciler) hasImageResets() bool {
+	for _, sidecarConfig := range r.conf.Load().Sidecars {
+		if sidecarConfig.ImageResetStep != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PodReconciler) sweepOwners(ctx context.Context) {
+	namespaceLabels := r.allNamespaceLabels(ctx)
+	var deployments appsv1.DeploymentList
+	if err := r.List(ctx, &deployments); err != nil {
+		slog.Error("error listing deployments for sweep", "err", err.Error())
+	}
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		target := ownerTarget{
+			kind:           config.Deployment,
+			namespacedName: types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name},
+			object:         deployment,
+			replicas:       replicas,
+			annotations:    deployment.Annotations,
+			podTemplate:    deployment.Spec.Template,
+			selector:       deployment.Spec.Selector,
+		}
+		workload := config.Workload{NamespaceLabels: namespaceLabels[deployment.Namespace], PodLabels: deployment.Spec.Template.Labels}
+		r.sweepOwner(ctx, target, workload)
+	}
+
+	var daemonSets appsv1.DaemonSetList
+	if err := r.List(ctx, &daemonSets); err != nil {
+		slog.Error("error listing daemon sets for sweep", "err", err.Error())
+	}
+	for i := range daemonSets.Items {
+		daemonSet := &daemonSets.Items[i]
+		target := ownerTarget{
+			kind:           config.DaemonSet,
+			namespacedName: types.NamespacedName{Namespace: daemonSet.Namespace, Name: daemonSet.Name},
+			object:         daemonSet,
+			replicas:       daemonSet.Status.DesiredNumberScheduled,
+			annotations:    daemonSet.Annotations,
+			podTemplate:    daemonSet.Spec.Template,
+			selector:       daemonSet.Spec.Selector,
+		}
+		workload := config.Workload{NamespaceLabels: namespaceLabels[daemonSet.Namespace], PodLabels: daemonSet.Spec.Template.Labels}
+		r.sweepOwner(ctx, target, workload)
+	}
+}
+
+// sweepOwner moves the owner's containers on or off their schedule floors, and back to their image reset step
+// if the owner's images changed. the step changes are settled like the ones from restarts, so they go through the
+// same checks and show up in events and the stored steps. the checks that look at a pod use the newest running pod
+// of the owner.
+func (r *PodReconciler) sweepOwner(ctx context.Context, target ownerTarget, workload config.Workload) {
+	dasDetailsStr, ok := target.annotations[dasDetailsKey]
+	if !ok {
+		return
+	}
+	images := r.ownerImages(ctx, &target)
+	res, err := r.modifier.sweepSteps(workload, target.annotations, target.podTemplate.Annotations, images)
+	if err != nil {
+		slog.Error("error sweeping owner steps", "err", err.Error(), "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace)
+		return
+	}
+	if res.ownerAnnotations[dasDetailsKey] == dasDetailsStr {
+		return
+	}
+
+	if target.pod == nil {
+		target.pod = r.ownerPod(ctx, target)
+	}
+	updated, err := r.settle(ctx, target, r.appName(target.object.GetLabels()), &res)
+	if err != nil {
+		slog.Error("error applying swept steps to owner", "err", err.Error(), "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace)
+		return
+	}
+	if r.storer == nil || len(updated.steps) == 0 {
+		return
+	}
+	eTag, err := r.storer.UploadNewSteps(updated.appName, updated.steps)
+	if err != nil {
+		slog.Error("error uploading swept steps", "err", err.Error(), "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace)
+		return
+	}
+	slog.Info("new steps successfully updated", "etag", eTag)
+}
+
+// ownerPod returns the newest running pod of the owner, or nil if there is none.
+func (r *PodReconciler) ownerPod(ctx context.Context, target ownerTarget) *corev1.Pod {
+	var res *corev1.Pod
+	for _, pod := range r.ownerPods(ctx, target) {
+		if res == nil || res.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			res = &pod
+		}
+	}
+	return res
+}
+
+// ownerPods returns the running pods matching the owner's selector.
+func (r *PodReconciler) ownerPods(ctx context.Context, target ownerTarget) []corev1.Pod {
+	labelSelector, err := metav1.LabelSelectorAsSelector(target.selector)
+	if err != nil || labelSelector.Empty() {
+		return nil
+	}
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(target.namespacedName.Namespace), client.MatchingLabelsSelector{Selector: labelSelector}); err != nil {
+		slog.Error("error listing pods of owner", "err", err.Error(), "owner_name", target.namespacedName.Name, "owner_namespace", target.namespacedName.Namespace)
+		return nil
+	}
+	var res []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning {
+			res = append(res, pod)
+		}
+	}
+	return res
+}
+
+// ownerImages are the images of the owner's containers as per its pod template. with image resets configured,
+// containers tracked in das details but not in the template, like sidecars injected by a webhook, are read from
+// the owner's newest running pod, which is kept in target for the checks.
+func (r *PodReconciler) ownerImages(ctx context.Context, target *ownerTarget) map[string]string {
+	images := make(map[string]string)
+	for _, container := range target.podTemplate.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+	if !r.hasImageResets() {
+		return images
+	}
+	var dasDetails map[string]dasDetail
+	if err := json.Unmarshal([]byte(target.annotations[dasDetailsKey]), &dasDetails); err != nil {
+		return images
+	}
+	for key := range dasDetails {
+		containerName, _ := splitDetailKey(key)
+		if _, ok := images[containerName]; ok {
+			continue
+		}
+		if target.pod == nil {
+			target.pod = r.ownerPod(ctx, *target)
+		}
+		if target.pod == nil {
+			return images
+		}
+		if image := containerImage(target.pod, containerName); image != "" {
+			images[containerName] = image
+		}
+	}
+	return images
+}
+
+// withTemplateImages takes the image of each container from the owner's pod template where it is set there,
+// so the image das records is the one the owner rolls out and not whatever the failing pod happened to run.
+func withTemplateImages(target ownerTarget, details []containerDetail) []containerDetail {
+	res := make([]containerDetail, 0, len(details))
+	for _, d := range details {
+		for _, container := range target.podTemplate.Spec.Containers {
+			if container.Name == d.containerStatus.Name {
+				d.image = container.Image
+			}
+		}
+		res = append(res, d)
+	}
+	return res
+}
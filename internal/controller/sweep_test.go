@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bento01dev/das/internal/blob"
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+type fakeStorer struct {
+	uploads []map[string]blob.StepRecord
+}
+
+func (s *fakeStorer) UploadNewSteps(appName string, steps map[string]blob.StepRecord) (string, error) {
+	s.uploads = append(s.uploads, steps)
+	return "test-etag", nil
+}
+
+// TestSweepFloorChecks checks a floor goes through the same checks and recording as a step change from restarts.
+func TestSweepFloorChecks(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		CPUAnnotationKey: "test-container/cpu",
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", RestartLimit: 1, CPURequest: "100m"},
+			{Name: "test-step-2", RestartLimit: 1, CPURequest: "200m"},
+			{Name: "test-step-3", RestartLimit: 1, CPURequest: "400m"},
+		},
+		Floors: []config.FloorRule{{Step: "test-step-3", Start: "00:00", End: "23:59"}},
+	}
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace:   "test",
+			Name:        "test-deployment",
+			Annotations: map[string]string{dasDetailsKey: `{"test-container":{"name":"test-step-1","restart_count":1}}`, "test-container/cpu": "100m"},
+		},
+	}
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: "test-limits"},
+		Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+			Type: corev1.LimitTypeContainer,
+			Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("300m")},
+		}}},
+	}
+	var sent map[string]any
+	c := fake.NewClientBuilder().
+		WithObjects(deployment, limitRange).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				data, err := patch.Data(obj)
+				if err != nil {
+					return err
+				}
+				return json.Unmarshal(data, &sent)
+			},
+		}).
+		Build()
+	store := config.NewStore(conf)
+	m := NewPodOwnerModifier(store)
+	m.now = func() time.Time { return time.Date(2024, 9, 16, 12, 0, 0, 0, time.UTC) }
+	s := &fakeStorer{}
+	recorder := record.NewFakeRecorder(10)
+	r := NewPodReconciler(c, store, m, s, recorder)
+	target := ownerTarget{
+		kind:           config.Deployment,
+		namespacedName: types.NamespacedName{Namespace: "test", Name: "test-deployment"},
+		object:         deployment,
+		replicas:       1,
+		annotations:    deployment.Annotations,
+	}
+
+	r.sweepOwner(context.Background(), target, config.Workload{})
+
+	metadata := sent["metadata"].(map[string]any)
+	annotations := metadata["annotations"].(map[string]any)
+	var details map[string]dasDetail
+	assert.NoError(t, json.Unmarshal([]byte(annotations[dasDetailsKey].(string)), &details))
+	// the floor is clamped to the limit range, and the learnt step stays as it is
+	assert.Equal(t, "test-step-1", details["test-container"].Name)
+	assert.Equal(t, 1, details["test-container"].RestartCount)
+	assert.Equal(t, "test-step-3", details["test-container"].Floor)
+	assert.Contains(t, details["test-container"].Adjustment, "clamped to limit range")
+	assert.Len(t, s.uploads, 1)
+	assert.Equal(t, "300m", s.uploads[0]["test-container"].CPURequest)
+	assert.Len(t, recorder.Events, 2)
+}
+
+func TestOwnerPod(t *testing.T) {
+	pod := func(name string, phase corev1.PodPhase, created time.Time, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{Namespace: "test", Name: name, Labels: labels, CreationTimestamp: v1.NewTime(created)},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	app := map[string]string{"app": "test"}
+	start := time.Date(2024, 9, 16, 12, 0, 0, 0, time.UTC)
+	c := fake.NewClientBuilder().WithObjects(
+		pod("old", corev1.PodRunning, start, app),
+		pod("new", corev1.PodRunning, start.Add(time.Hour), app),
+		pod("pending", corev1.PodPending, start.Add(2*time.Hour), app),
+		pod("other", corev1.PodRunning, start.Add(3*time.Hour), map[string]string{"app": "other"}),
+	).Build()
+	r := &PodReconciler{Client: c}
+
+	res := r.ownerPod(context.Background(), ownerTarget{namespacedName: types.NamespacedName{Namespace: "test"}, selector: &v1.LabelSelector{MatchLabels: app}})
+	assert.Equal(t, "new", res.Name)
+	assert.Nil(t, r.ownerPod(context.Background(), ownerTarget{namespacedName: types.NamespacedName{Namespace: "test"}}))
+}
+
+// TestSweepImageReset checks a new image rolled out on the owner template drops the sidecar back without waiting for a restart.
+func TestSweepImageReset(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		CPUAnnotationKey: "test-container/cpu",
+		ImageResetStep:   "test-step-1",
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", RestartLimit: 1, CPURequest: "100m"},
+			{Name: "test-step-2", RestartLimit: 1, CPURequest: "200m"},
+		},
+	}
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace:   "test",
+			Name:        "test-deployment",
+			Annotations: map[string]string{dasDetailsKey: `{"test-container":{"name":"test-step-2","restart_count":0,"image":"envoy:1.30","keys":["test-container/cpu"]}}`},
+		},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{"test-container/cpu": "200m"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test-container", Image: "envoy:1.31"}}},
+		}},
+	}
+	var sent map[string]any
+	c := fake.NewClientBuilder().
+		WithObjects(deployment).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				data, err := patch.Data(obj)
+				if err != nil {
+					return err
+				}
+				return json.Unmarshal(data, &sent)
+			},
+		}).
+		Build()
+	store := config.NewStore(conf)
+	r := NewPodReconciler(c, store, NewPodOwnerModifier(store), nil, nil)
+	target := ownerTarget{
+		kind:           config.Deployment,
+		namespacedName: types.NamespacedName{Namespace: "test", Name: "test-deployment"},
+		object:         deployment,
+		replicas:       1,
+		annotations:    deployment.Annotations,
+		podTemplate:    deployment.Spec.Template,
+	}
+
+	r.sweepOwner(context.Background(), target, config.Workload{})
+
+	metadata := sent["metadata"].(map[string]any)
+	var details map[string]dasDetail
+	assert.NoError(t, json.Unmarshal([]byte(metadata["annotations"].(map[string]any)[dasDetailsKey].(string)), &details))
+	assert.Equal(t, dasDetail{Name: "test-step-1", Image: "envoy:1.31", Keys: []string{"test-container/cpu"}}, details["test-container"])
+	template := sent["spec"].(map[string]any)["template"].(map[string]any)
+	assert.Equal(t, "100m", template["metadata"].(map[string]any)["annotations"].(map[string]any)["test-container/cpu"])
+}
+
+func TestWithTemplateImages(t *testing.T) {
+	target := ownerTarget{podTemplate: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test-container", Image: "envoy:1.31"}}}}}
+	details := []containerDetail{
+		{containerStatus: corev1.ContainerStatus{Name: "test-container"}, image: "envoy:1.30"},
+		{containerStatus: corev1.ContainerStatus{Name: "injected"}, image: "istio/proxyv2:1.22"},
+	}
+	res := withTemplateImages(target, details)
+	assert.Equal(t, "envoy:1.31", res[0].image)
+	// a container the template does not have keeps the image from the pod
+	assert.Equal(t, "istio/proxyv2:1.22", res[1].image)
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/bento01dev/das/internal/api/v1alpha1"
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// teamPolicyResult is what das made of a team sidecar policy.
+type teamPolicyResult struct {
+	sidecarConfig config.SidecarConfig
+	errs          []string
+	adjustments   []string
+}
+
+// teamPolicyKey is the key of a team policy's sidecar in the config. names cannot have a /, so it cannot clash
+// with sidecar policies.
+func teamPolicyKey(policy v1alpha1.TeamSidecarPolicy) string {
+	return policy.Namespace + "/" + policy.Name
+}
+
+// teamPolicySidecars works out the sidecars team policies add to the config, keyed by namespace and name.
+func teamPolicySidecars(base config.Config, guardrails []v1alpha1.SidecarGuardrail, policies []v1alpha1.TeamSidecarPolicy) map[string]config.SidecarConfig {
+	res := make(map[string]config.SidecarConfig)
+	for _, policy := range policies {
+		result := teamPolicySidecar(base, guardrails, policy)
+		if len(result.errs) > 0 {
+			continue
+		}
+		res[teamPolicyKey(policy)] = result.sidecarConfig
+	}
+	return res
+}
+
+// teamPolicySidecar scopes a team policy to its namespace and brings it within every guardrail for its container.
+// a container without a guardrail cannot be configured by teams. ladders are looked up in the base config.
+func teamPolicySidecar(base config.Config, guardrails []v1alpha1.SidecarGuardrail, policy v1alpha1.TeamSidecarPolicy) teamPolicyResult {
+	var res teamPolicyResult
+	sidecarConfig, err := base.WithLadder(policy.Spec.SidecarConfig)
+	if err == nil {
+		sidecarConfig, err = sidecarConfig.WithProfile()
+	}
+	if err != nil {
+		res.errs = append(res.errs, err.Error())
+		return res
+	}
+	// guardrails are matched to the container as written, so a regex could reach containers no guardrail covers.
+	if sidecarConfig.ContainerRegex != "" {
+		res.errs = append(res.errs, "container_regex is not allowed in team policies")
+		return res
+	}
+	if sidecarConfig.Container == "" {
+		sidecarConfig.Container = policy.ContainerName()
+	}
+	if sidecarConfig.NamespaceSelector != nil {
+		res.adjustments = append(res.adjustments, "namespace_selector replaced with the policy's namespace")
+	}
+	sidecarConfig.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: policy.Namespace}}
+
+	guardrails = slices.Clone(guardrails)
+	slices.SortFunc(guardrails, func(a, b v1alpha1.SidecarGuardrail) int { return strings.Compare(a.Name, b.Name) })
+	found := false
+	for _, guardrail := range guardrails {
+		if guardrail.ContainerName() != sidecarConfig.Container {
+			continue
+		}
+		found = true
+		enforced, adjustments, err := guardrail.Spec.ClusterGuardrail.Enforce(sidecarConfig)
+		for _, adjustment := range adjustments {
+			res.adjustments = append(res.adjustments, fmt.Sprintf("sidecar guardrail %s: %s", guardrail.Name, adjustment))
+		}
+		if err != nil {
+			for _, msg := range strings.Split(err.Error(), "\n") {
+				res.errs = append(res.errs, fmt.Sprintf("sidecar guardrail %s: %s", guardrail.Name, msg))
+			}
+			continue
+		}
+		sidecarConfig = enforced
+	}
+	if !found {
+		res.errs = append(res.errs, fmt.Sprintf("no sidecar guardrail for container %s", sidecarConfig.Container))
+	}
+	if len(res.errs) > 0 {
+		return res
+	}
+	err = config.Config{Sidecars: map[string]config.SidecarConfig{teamPolicyKey(policy): sidecarConfig}, Ladders: base.Ladders}.Validate()
+	if err != nil {
+		res.errs = append(res.errs, strings.Split(err.Error(), "\n")...)
+		return res
+	}
+	res.sidecarConfig = sidecarConfig
+	return res
+}
+
+// TeamSidecarPolicyReconciler reports in the status of each team policy whether das uses it, why not, and what das
+// changed to keep it within the guardrails.
+type TeamSidecarPolicyReconciler struct {
+	client.Client
+	store *config.Store
+}
+
+func (r *TeamSidecarPolicyReconciler) SetupWithManager(manager ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(manager).
+		Named("teamsidecarpolicy").
+		For(&v1alpha1.TeamSidecarPolicy{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&v1alpha1.SidecarGuardrail{}, handler.EnqueueRequestsFromMapFunc(r.allPolicies)).
+		WatchesRawSource(reloadSource(r.store, r.allPolicies)).
+		Complete(r)
+}
+
+func (r *TeamSidecarPolicyReconciler) allPolicies(ctx context.Context, _ client.Object) []reconcile.Request {
+	var policies v1alpha1.TeamSidecarPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		slog.Error("error listing team sidecar policies", "err", err.Error())
+		return nil
+	}
+	res := make([]reconcile.Request, 0, len(policies.Items))
+	for _, policy := range policies.Items {
+		res = append(res, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}})
+	}
+	return res
+}
+
+func (r *TeamSidecarPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var policy v1alpha1.TeamSidecarPolicy
+	err := r.Get(ctx, req.NamespacedName, &policy)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("error getting team sidecar policy %v: %w", req.NamespacedName, err)
+	}
+	var guardrails v1alpha1.SidecarGuardrailList
+	err = r.List(ctx, &guardrails)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error listing sidecar guardrails: %w", err)
+	}
+	result := teamPolicySidecar(r.store.Base(), guardrails.Items, policy)
+	status := v1alpha1.TeamSidecarPolicyStatus{
+		ObservedGeneration: policy.Generation,
+		Active:             len(result.errs) == 0,
+		Errors:             result.errs,
+		Adjustments:        result.adjustments,
+	}
+	if equality.Semantic.DeepEqual(policy.Status, status) {
+		return ctrl.Result{}, nil
+	}
+	policy.Status = status
+	err = r.Status().Update(ctx, &policy)
+	if err != nil {
+		slog.Error("error updating team sidecar policy status", "policy_name", policy.Name, "policy_namespace", policy.Namespace, "err", err.Error())
+		return ctrl.Result{}, fmt.Errorf("error updating status of team sidecar policy %v: %w", req.NamespacedName, err)
+	}
+	return ctrl.Result{}, nil
+}
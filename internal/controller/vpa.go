@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/bento01dev/das/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VPA is a CRD, so das works with it as unstructured instead of pulling in the autoscaler module for its types.
+var vpaGVK = schema.GroupVersionKind{Group: "autoscaling.k8s.io", Version: "v1", Kind: "VerticalPodAutoscaler"}
+
+func (r *PodReconciler) findVPA(ctx context.Context, ownerKind config.Owner, ownerNamespacedName types.NamespacedName) (*unstructured.Unstructured, error) {
+	var vpaList unstructured.UnstructuredList
+	vpaList.SetGroupVersionKind(vpaGVK.GroupVersion().WithKind(vpaGVK.Kind + "List"))
+	if err := r.List(ctx, &vpaList, client.InNamespace(ownerNamespacedName.Namespace)); err != nil {
+		return nil, fmt.Errorf("error listing vpas in %s: %w", ownerNamespacedName.Namespace, err)
+	}
+	for i := range vpaList.Items {
+		kind, _, _ := unstructured.NestedString(vpaList.Items[i].Object, "spec", "targetRef", "kind")
+		name, _, _ := unstructured.NestedString(vpaList.Items[i].Object, "spec", "targetRef", "name")
+		if config.Owner(kind) == ownerKind && name == ownerNamespacedName.Name {
+			return &vpaList.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// withVPARecommendations sets the VPA target recommendation on the details of sidecars that are configured to use it.
+// a missing VPA or recommendation just means das steps up as usual.
+func (r *PodReconciler) withVPARecommendations(ctx context.Context, ownerKind config.Owner, ownerNamespacedName types.NamespacedName, details []containerDetail) []containerDetail {
+	var vpa *unstructured.Unstructured
+	looked := false
+	for i := range details {
+		if !details[i].sidecarConfig.VPA.UseRecommendation {
+			continue
+		}
+		if !looked {
+			var err error
+			vpa, err = r.findVPA(ctx, ownerKind, ownerNamespacedName)
+			if err != nil {
+				slog.Warn("could not look up vpa for recommendation", "err", err.Error(), "owner_name", ownerNamespacedName.Name, "owner_namespace", ownerNamespacedName.Namespace)
+			}
+			looked = true
+		}
+		if vpa == nil {
+			return details
+		}
+		details[i].recommendation = vpaRecommendation(vpa, details[i].containerStatus.Name)
+	}
+	return details
+}
+
+func vpaRecommendation(vpa *unstructured.Unstructured, containerName string) corev1.ResourceList {
+	recommendations, _, _ := unstructured.NestedSlice(vpa.Object, "status", "recommendation", "containerRecommendations")
+	for _, r := range recommendations {
+		recommendation, ok := r.(map[string]any)
+		if !ok || recommendation["containerName"] != containerName {
+			continue
+		}
+		target, _, _ := unstructured.NestedStringMap(recommendation, "target")
+		res := make(corev1.ResourceList)
+		for name, value := range target {
+			q, err := resource.ParseQuantity(value)
+			if err != nil {
+				continue
+			}
+			res[corev1.ResourceName(name)] = q
+		}
+		return res
+	}
+	return nil
+}
+
+// publishVPASteps sets the minAllowed for each sidecar with a new step in the VPA targeting the owner,
+// so that the VPA never recommends below what das has learnt from restarts.
+// if the owner has no VPA, one is created in recommendation mode. as with hpas, failures are only logged and noted.
+// the VPA is server side applied under the das field manager with only the container policies, so the rest of
+// a VPA someone else set up is left to them. the container policies are an atomic list, so the whole list is sent
+// as it was read, along with the resource version it was read at.
+func (r *PodReconciler) publishVPASteps(ctx context.Context, ownerKind config.Owner, ownerNamespacedName types.NamespacedName, decisions []decision) {
+	var changed []*decision
+	for i := range decisions {
+		if !decisions[i].held && decisions[i].sidecarConfig.VPA.Enabled {
+			changed = append(changed, &decisions[i])
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	vpa, err := r.findVPA(ctx, ownerKind, ownerNamespacedName)
+	if err != nil {
+		slog.Error("error looking up vpa for owner", "err", err.Error(), "owner_name", ownerNamespacedName.Name, "owner_namespace", ownerNamespacedName.Namespace)
+		for _, d := range changed {
+			d.notes = append(d.notes, fmt.Sprintf("could not publish step to vpa: %s", err.Error()))
+		}
+		return
+	}
+	// a VPA das created is applied in full every time, since leaving a field out would remove it.
+	apply := newVPA(ownerKind, ownerNamespacedName)
+	var policies []any
+	if vpa != nil {
+		policies, _, _ = unstructured.NestedSlice(vpa.Object, "spec", "resourcePolicy", "containerPolicies")
+		if vpa.GetName() != apply.GetName() {
+			apply = &unstructured.Unstructured{Object: map[string]any{}}
+			apply.SetGroupVersionKind(vpaGVK)
+			apply.SetName(vpa.GetName())
+			apply.SetNamespace(vpa.GetNamespace())
+		}
+		apply.SetResourceVersion(vpa.GetResourceVersion())
+	}
+
+	for _, d := range changed {
+		policies = setMinAllowed(policies, d.container, d.to)
+	}
+	if err := unstructured.SetNestedSlice(apply.Object, policies, "spec", "resourcePolicy", "containerPolicies"); err != nil {
+		slog.Error("error setting vpa container policies", "err", err.Error(), "vpa_name", apply.GetName())
+		return
+	}
+
+	err = r.Patch(ctx, apply, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+	for _, d := range changed {
+		if err != nil {
+			d.notes = append(d.notes, fmt.Sprintf("failed publishing step to vpa %s: %s", apply.GetName(), err.Error()))
+			continue
+		}
+		d.notes = append(d.notes, fmt.Sprintf("set minAllowed in vpa %s to step %s", apply.GetName(), d.to.Name))
+	}
+	if err != nil {
+		slog.Error("error publishing steps to vpa", "err", err.Error(), "vpa_name", apply.GetName(), "vpa_namespace", apply.GetNamespace())
+	}
+}
+
+func newVPA(ownerKind config.Owner, ownerNamespacedName types.NamespacedName) *unstructured.Unstructured {
+	vpa := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"targetRef": map[string]any{
+				"apiVersion": "apps/v1",
+				"kind":       string(ownerKind),
+				"name":       ownerNamespacedName.Name,
+			},
+			"updatePolicy": map[string]any{
+				"updateMode": "Off",
+			},
+		},
+	}}
+	vpa.SetGroupVersionKind(vpaGVK)
+	vpa.SetName(ownerNamespacedName.Name + "-das")
+	vpa.SetNamespace(ownerNamespacedName.Namespace)
+	return vpa
+}
+
+func setMinAllowed(policies []any, containerName string, step config.ResourceStep) []any {
+	minAllowed := map[string]any{}
+	if step.CPURequest != "" {
+		minAllowed["cpu"] = step.CPURequest
+	}
+	if step.MemRequest != "" {
+		minAllowed["memory"] = step.MemRequest
+	}
+	for i, p := range policies {
+		policy, ok := p.(map[string]any)
+		if !ok || policy["containerName"] != containerName {
+			continue
+		}
+		policy["minAllowed"] = minAllowed
+		policies[i] = policy
+		return policies
+	}
+	return append(policies, map[string]any{"containerName": containerName, "minAllowed": minAllowed})
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bento01dev/das/internal/config"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func testVPA(name string, spec map[string]any, status map[string]any) *unstructured.Unstructured {
+	vpa := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	if status != nil {
+		vpa.Object["status"] = status
+	}
+	vpa.SetGroupVersionKind(vpaGVK)
+	vpa.SetName(name)
+	vpa.SetNamespace("test")
+	return vpa
+}
+
+var testVPATargetRef = map[string]any{"apiVersion": "apps/v1", "kind": "Deployment", "name": "test-deployment"}
+
+// vpaApplies builds a client recording the body of every apply to a VPA.
+func vpaApplies(objects ...client.Object) (client.WithWatch, *[]map[string]any) {
+	var applies []map[string]any
+	c := fake.NewClientBuilder().
+		WithObjects(objects...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if _, ok := obj.(*unstructured.Unstructured); !ok || patch.Type() != types.ApplyPatchType {
+					return nil
+				}
+				data, err := patch.Data(obj)
+				if err != nil {
+					return err
+				}
+				var sent map[string]any
+				if err := json.Unmarshal(data, &sent); err != nil {
+					return err
+				}
+				applies = append(applies, sent)
+				return nil
+			},
+		}).
+		Build()
+	return c, &applies
+}
+
+func TestVPARecommendation(t *testing.T) {
+	vpa := testVPA("test-vpa", map[string]any{"targetRef": testVPATargetRef}, map[string]any{
+		"recommendation": map[string]any{
+			"containerRecommendations": []any{
+				map[string]any{"containerName": "other-container", "target": map[string]any{"cpu": "2"}},
+				map[string]any{"containerName": "test-container", "target": map[string]any{"cpu": "300m", "memory": "256Mi", "bogus": "lots"}},
+			},
+		},
+	})
+
+	assert.Equal(t, corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("300m"),
+		corev1.ResourceMemory: resource.MustParse("256Mi"),
+	}, vpaRecommendation(vpa, "test-container"))
+	assert.Nil(t, vpaRecommendation(vpa, "missing-container"))
+}
+
+func TestPublishVPASteps(t *testing.T) {
+	step := config.ResourceStep{Name: "test-step-2", CPURequest: "200m", MemRequest: "256Mi"}
+	testcases := []struct {
+		name     string
+		objects  []client.Object
+		expected map[string]any
+	}{
+		{
+			name: "create a vpa in recommendation mode when the owner has none",
+			expected: map[string]any{
+				"apiVersion": "autoscaling.k8s.io/v1",
+				"kind":       "VerticalPodAutoscaler",
+				"metadata":   map[string]any{"name": "test-deployment-das", "namespace": "test"},
+				"spec": map[string]any{
+					"targetRef":    testVPATargetRef,
+					"updatePolicy": map[string]any{"updateMode": "Off"},
+					"resourcePolicy": map[string]any{"containerPolicies": []any{
+						map[string]any{"containerName": "test-container", "minAllowed": map[string]any{"cpu": "200m", "memory": "256Mi"}},
+					}},
+				},
+			},
+		},
+		{
+			name: "apply only the container policies to a vpa someone else set up",
+			objects: []client.Object{testVPA("test-vpa", map[string]any{
+				"targetRef":    testVPATargetRef,
+				"updatePolicy": map[string]any{"updateMode": "Auto"},
+				"resourcePolicy": map[string]any{"containerPolicies": []any{
+					map[string]any{"containerName": "app", "maxAllowed": map[string]any{"cpu": "4"}},
+					map[string]any{"containerName": "test-container", "mode": "Auto", "maxAllowed": map[string]any{"cpu": "1"}, "minAllowed": map[string]any{"cpu": "100m"}},
+				}},
+			}, nil)},
+			expected: map[string]any{
+				"apiVersion": "autoscaling.k8s.io/v1",
+				"kind":       "VerticalPodAutoscaler",
+				"metadata":   map[string]any{"name": "test-vpa", "namespace": "test", "resourceVersion": "999"},
+				"spec": map[string]any{
+					"resourcePolicy": map[string]any{"containerPolicies": []any{
+						map[string]any{"containerName": "app", "maxAllowed": map[string]any{"cpu": "4"}},
+						map[string]any{"containerName": "test-container", "mode": "Auto", "maxAllowed": map[string]any{"cpu": "1"}, "minAllowed": map[string]any{"cpu": "200m", "memory": "256Mi"}},
+					}},
+				},
+			},
+		},
+		{
+			name: "apply the vpa das created in full",
+			objects: []client.Object{testVPA("test-deployment-das", map[string]any{
+				"targetRef":    testVPATargetRef,
+				"updatePolicy": map[string]any{"updateMode": "Off"},
+				"resourcePolicy": map[string]any{"containerPolicies": []any{
+					map[string]any{"containerName": "test-container", "minAllowed": map[string]any{"cpu": "100m"}},
+				}},
+			}, nil)},
+			expected: map[string]any{
+				"apiVersion": "autoscaling.k8s.io/v1",
+				"kind":       "VerticalPodAutoscaler",
+				"metadata":   map[string]any{"name": "test-deployment-das", "namespace": "test", "resourceVersion": "999"},
+				"spec": map[string]any{
+					"targetRef":    testVPATargetRef,
+					"updatePolicy": map[string]any{"updateMode": "Off"},
+					"resourcePolicy": map[string]any{"containerPolicies": []any{
+						map[string]any{"containerName": "test-container", "minAllowed": map[string]any{"cpu": "200m", "memory": "256Mi"}},
+					}},
+				},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			c, applies := vpaApplies(testcase.objects...)
+			r := &PodReconciler{Client: c}
+			decisions := []decision{
+				{container: "test-container", sidecarConfig: config.SidecarConfig{VPA: config.VPAConfig{Enabled: true}}, to: step},
+				{container: "held-container", sidecarConfig: config.SidecarConfig{VPA: config.VPAConfig{Enabled: true}}, to: step, held: true},
+			}
+			r.publishVPASteps(context.Background(), config.Deployment, types.NamespacedName{Namespace: "test", Name: "test-deployment"}, decisions)
+
+			assert.Equal(t, []map[string]any{testcase.expected}, *applies)
+			name := testcase.expected["metadata"].(map[string]any)["name"]
+			assert.Equal(t, []string{"set minAllowed in vpa " + name.(string) + " to step test-step-2"}, decisions[0].notes)
+			assert.Empty(t, decisions[1].notes)
+		})
+	}
+}
+
+// TestCommitVPARecommendation checks a sidecar using the vpa recommendation skips ahead to the step covering it,
+// and publishes that step back to the vpa.
+func TestCommitVPARecommendation(t *testing.T) {
+	sidecarConfig := config.SidecarConfig{
+		Owner:            config.Deployment,
+		CPUAnnotationKey: "test-container/cpu",
+		VPA:              config.VPAConfig{Enabled: true, UseRecommendation: true},
+		Steps: []config.ResourceStep{
+			{Name: "test-step-1", RestartLimit: 1, CPURequest: "100m"},
+			{Name: "test-step-2", RestartLimit: 1, CPURequest: "200m"},
+			{Name: "test-step-3", RestartLimit: 1, CPURequest: "500m"},
+			{Name: "test-step-4", RestartLimit: 1, CPURequest: "1"},
+		},
+	}
+	conf := config.Config{Sidecars: map[string]config.SidecarConfig{"test-container": sidecarConfig}}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace:   "test",
+			Name:        "test-deployment",
+			Annotations: map[string]string{dasDetailsKey: `{"test-container":{"name":"test-step-1","restart_count":1}}`},
+		},
+	}
+	vpa := testVPA("test-vpa", map[string]any{"targetRef": testVPATargetRef}, map[string]any{
+		"recommendation": map[string]any{
+			"containerRecommendations": []any{
+				map[string]any{"containerName": "test-container", "target": map[string]any{"cpu": "300m"}},
+			},
+		},
+	})
+	c, applies := vpaApplies(deployment, vpa)
+	store := config.NewStore(conf)
+	r := NewPodReconciler(c, store, NewPodOwnerModifier(store), nil, nil)
+	target := ownerTarget{
+		kind:           config.Deployment,
+		namespacedName: types.NamespacedName{Namespace: "test", Name: "test-deployment"},
+		object:         deployment,
+		replicas:       1,
+		annotations:    deployment.Annotations,
+	}
+	details := []containerDetail{{sidecarConfig: sidecarConfig, containerStatus: corev1.ContainerStatus{Name: "test-container"}}}
+
+	res, err := r.commit(context.Background(), target, "test-app", details)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-step-3", res.steps["test-container"].Name)
+	assert.Len(t, *applies, 1)
+	policies, _, _ := unstructured.NestedSlice((*applies)[0], "spec", "resourcePolicy", "containerPolicies")
+	assert.Equal(t, []any{map[string]any{"containerName": "test-container", "minAllowed": map[string]any{"cpu": "500m"}}}, policies)
+}